@@ -14,9 +14,15 @@ export CGO_ENABLED=0
 export GOOS=linux
 export GOARCH=amd64
 
+# 构建信息，通过 /version 查看
+VERSION="$(git describe --tags --always --dirty 2>/dev/null || echo dev)"
+COMMIT="$(git rev-parse HEAD 2>/dev/null || true)"
+BUILD_TIME="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
 build_webhook() {
-    echo "Building webhook"
+    echo "Building webhook ${VERSION}"
     go build -trimpath \
+   	-ldflags "-X go-sms/util.Version=${VERSION} -X go-sms/util.Commit=${COMMIT} -X go-sms/util.BuildTime=${BUILD_TIME}" \
    	-o webhook
 }
 # 构建二进制文件
@@ -53,10 +59,32 @@ ssh root@schedulemaster "systemctl daemon-reload && systemctl restart webhook &&
 # curl -X POST -H "Content-Type: application/json" -d '{"receiver":"webhook-receiver","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"Watchdog","environment":"schedulemaster","severity":"warning"},"annotations":{"description":"This is an alert meant to ensure that the entire alerting pipeline is functional.\nThis alert is always firing, therefore it should always be firing in Alertmanager\nand always fire against a receiver. There are integrations with various notification\nmechanisms that send a notification when this alert is not firing. For example the\n\"DeadMansSnitch\" integration in PagerDuty.","summary":"Ensure entire alerting pipeline is functional"},"startsAt":"2024-12-18T10:35:30.61Z","endsAt":"0001-01-01T00:00:00Z","generatorURL":"http://schedulemaster:9090/graph?g0.expr=vector%281%29\u0026g0.tab=1","fingerprint":"0c7b31e25484f81a"}],"groupLabels":{"alertname":"Watchdog"},"commonLabels":{"alertname":"Watchdog","environment":"schedulemaster","severity":"warning"},"commonAnnotations":{"description":"This is an alert meant to ensure that the entire alerting pipeline is functional.\nThis alert is always firing, therefore it should always be firing in Alertmanager\nand always fire against a receiver. There are integrations with various notification\nmechanisms that send a notification when this alert is not firing. For example the\n\"DeadMansSnitch\" integration in PagerDuty.","summary":"Ensure entire alerting pipeline is functional"},"externalURL":"http://172.16.97.110:9093","version":"4","groupKey":"{}:{alertname=\"Watchdog\"}","truncatedAlerts":0}' http://172.16.97.110:8080/webhook
 # curl -X POST -H "Content-Type: application/json" -d '{"receiver":"webhook-receiver","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"Watchdog","environment":"schedulemaster","severity":"warning"},"annotations":{"description":"This is an alert meant to ensure that the entire alerting pipeline is functional.\nThis alert is always firing, therefore it should always be firing in Alertmanager\nand always fire against a receiver. There are integrations with various notification\nmechanisms that send a notification when this alert is not firing. For example the\n\"DeadMansSnitch\" integration in PagerDuty.","summary":"Ensure entire alerting pipeline is functional"},"startsAt":"2024-12-18T10:35:30.61Z","endsAt":"0001-01-01T00:00:00Z","generatorURL":"http://schedulemaster:9090/graph?g0.expr=vector%281%29\u0026g0.tab=1","fingerprint":"0c7b31e25484f81a"}],"groupLabels":{"alertname":"Watchdog"},"commonLabels":{"alertname":"Watchdog","environment":"schedulemaster","severity":"warning"},"commonAnnotations":{"description":"This is an alert meant to ensure that the entire alerting pipeline is functional.\nThis alert is always firing, therefore it should always be firing in Alertmanager\nand always fire against a receiver. There are integrations with various notification\nmechanisms that send a notification when this alert is not firing. For example the\n\"DeadMansSnitch\" integration in PagerDuty.","summary":"Ensure entire alerting pipeline is functional"},"externalURL":"http://172.16.97.110:9093","version":"4","groupKey":"{}:{alertname=\"Watchdog\"}","truncatedAlerts":0}' http://172.16.97.110:8080/test/webhook
 # curl -X POST -H "Content-Type: application/json" http://172.16.97.110:8080/health
+# curl -X GET http://172.16.97.110:8080/healthz
+# curl -X GET http://172.16.97.110:8080/readyz
+# curl -X GET http://172.16.97.110:8080/version
 # curl -X POST -H "Content-Type: application/json" http://172.16.97.110:8080/del/mysql
+# curl -X POST "http://172.16.97.110:8080/del/mysql?dry_run=true"
+# curl -X POST "http://172.16.97.110:8080/del/mysql?backup=file"
+# curl -X POST "http://172.16.97.110:8080/api/ds/maintenance?dry_run=true"
+# curl -X POST "http://172.16.97.110:8080/api/sync/schema?dry_run=true"
+# curl -X POST "http://172.16.97.110:8080/seatunnel/mysql/pg?mode=incremental"
+# curl -X POST "http://172.16.97.110:8080/seatunnel/mysql/pg?tables=t_ds_user,t_ds_project"
+# curl -X POST "http://172.16.97.110:8080/seatunnel/mysql/pg?verify=checksum&repair=true"
+# curl -X POST "http://172.16.97.110:8080/api/sync/verify?tables=t_ds_task_instance"
+# ./webhook -schema-sync dry-run > schema.sql
+# ./webhook -del-mysql dry-run
+# ./webhook -del-mysql run -backup table
+# ./webhook -restore-del-mysql <backup_id|backup/del_mysql_<backup_id>.jsonl>
 # curl -X GET  http://172.16.97.110:8080/
 
 # curl -X POST http://localhost:8083/api/process/visits
 
 # curl -X POST http://localhost:8083/api/process/mz
 # curl -X POST http://localhost:8083/api/validate/patient
+# curl -X GET http://localhost:8083/api/jobs
+# curl -X GET http://localhost:8083/api/jobs/<job_id>
+# curl -X GET "http://localhost:8083/api/jobs/history?page=1&page_size=20&job_type=process_visits"
+# curl -X DELETE http://localhost:8083/api/jobs/<job_id>
+# curl -X GET "http://localhost:8083/api/dify/usage?date=2025-09-01&job_type=process_mz&group_by=stage"
+# curl -X GET http://localhost:8083/api/cron
+# curl -X POST http://localhost:8083/api/cron/process_mz/run
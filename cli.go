@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"go-sms/util"
+
+	"github.com/spf13/viper"
+)
+
+// 命令行运维命令，执行后退出不启动HTTP服务
+var (
+	delMysqlFlag        = flag.String("del-mysql", "", "清理DolphinScheduler重复任务定义后退出: dry-run只输出删除计划 / run执行删除")
+	backupFlag          = flag.String("backup", "", "-del-mysql run的备份方式: none / table / file，默认DEL_MYSQL.BACKUP")
+	restoreDelMysqlFlag = flag.String("restore-del-mysql", "", "从备份恢复被删除的任务定义后退出: 备份表中的backup_id或JSONL备份文件路径")
+	schemaSyncFlag      = flag.String("schema-sync", "", "同步mysql_src表结构到postgres_tgt后退出: dry-run只输出DDL / apply执行DDL")
+)
+
+// runCommand 执行命令行指定的运维命令，未指定命令时返回false
+func runCommand() bool {
+	if *delMysqlFlag == "" && *restoreDelMysqlFlag == "" && *schemaSyncFlag == "" {
+		return false
+	}
+	// 标准输出只输出命令结果，日志写到标准错误
+	viper.Set("LOG.OUTPUT", "stderr")
+	if err := util.InitLogger(); err != nil {
+		fatal("Error initializing logger", err)
+	}
+	// stdout导出的span同样写到标准错误
+	initTracing(os.Stderr)
+	if err := util.InitDB(); err != nil {
+		fatal("Error initializing databases", err)
+	}
+
+	ctx := context.Background()
+	var result any
+	var err error
+	switch {
+	case *schemaSyncFlag != "":
+		err = runSchemaSync(ctx)
+	case *restoreDelMysqlFlag != "":
+		db := mustDB(util.DBDolphinScheduler)
+		result, err = util.RestoreDelMysql(ctx, db, *restoreDelMysqlFlag)
+	case *delMysqlFlag == "dry-run" || *delMysqlFlag == "run":
+		db := mustDB(util.DBDolphinScheduler)
+		result, err = util.RunDelMysql(ctx, db, util.DelMysqlOptions{
+			DryRun: *delMysqlFlag == "dry-run",
+			Backup: *backupFlag,
+		})
+	default:
+		err = fmt.Errorf("-del-mysql只能为dry-run或run: %s", *delMysqlFlag)
+	}
+
+	// 删除计划、执行与恢复结果以JSON输出
+	if result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	}
+	if err != nil {
+		fatal("Command failed", err)
+	}
+	return true
+}
+
+// runSchemaSync 同步表结构，DDL以SQL输出便于审阅，转换警告与错误作为注释
+func runSchemaSync(ctx context.Context) error {
+	if *schemaSyncFlag != "dry-run" && *schemaSyncFlag != "apply" {
+		return fmt.Errorf("-schema-sync只能为dry-run或apply: %s", *schemaSyncFlag)
+	}
+	src, err := util.DBs.Config(util.DBMySQLSrc)
+	if err != nil {
+		return err
+	}
+	tgt, err := util.DBs.Config(util.DBPostgresTgt)
+	if err != nil {
+		return err
+	}
+	report, err := util.SyncSchema(ctx, mustDB(util.DBMySQLSrc), mustDB(util.DBPostgresTgt), src, tgt, nil, *schemaSyncFlag == "dry-run")
+	if err != nil {
+		return err
+	}
+	for _, t := range report.Tables {
+		fmt.Printf("-- %s -> %s\n", t.Table, t.Target)
+		for _, w := range t.Warnings {
+			fmt.Printf("-- 警告: %s\n", w)
+		}
+		if t.Error != "" {
+			fmt.Printf("-- 错误: %s\n", t.Error)
+		}
+		for _, stmt := range t.Statements {
+			fmt.Printf("%s;\n", stmt)
+		}
+		fmt.Println()
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		return fmt.Errorf("%d张表结构同步失败", len(failed))
+	}
+	return nil
+}
+
+// mustDB 获取命名连接池，未配置时退出
+func mustDB(name string) *sql.DB {
+	db, err := util.DBs.Get(name)
+	if err != nil {
+		fatal("Error getting database", err)
+	}
+	return db
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"go-sms/routes" // 导入routes包
@@ -12,6 +19,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"          // 添加prometheus包导入
 	"github.com/prometheus/client_golang/prometheus/promhttp" // 添加promhttp包导入
 	"github.com/spf13/viper"                                  // 引入viper库
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var httpRequestsTotal = prometheus.NewCounterVec(
@@ -22,67 +30,189 @@ var httpRequestsTotal = prometheus.NewCounterVec(
 	[]string{"method", "path", "status"},
 )
 
+// httpRequestDuration 请求耗时，path使用路由模板避免标签基数膨胀
+var httpRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route template.",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"method", "path", "status"},
+)
+
 func init() {
 
 	// 初始化配置
-    if err := util.InitConfig(); err != nil {
-        log.Fatalf("Error initializing config: %s", err)
-    }
+	if err := util.InitConfig(); err != nil {
+		fatal("Error initializing config", err)
+	}
+	// 按LOG配置初始化结构化日志
+	if err := util.InitLogger(); err != nil {
+		fatal("Error initializing logger", err)
+	}
 	// 注册Prometheus指标
-	prometheus.MustRegister(httpRequestsTotal)
+	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration)
 }
 
 func main() {
+	// 命令行运维命令，如 -del-mysql dry-run
+	flag.Parse()
+	if runCommand() {
+		flushTracing()
+		return
+	}
+	// 链路追踪，默认不导出
+	initTracing(os.Stdout)
+	go flushTracingOnSignal()
+
 	// 设置 Gin 运行为 release 模式
 	gin.SetMode(gin.ReleaseMode)
 	// 创建一个不带默认中间件的Gin引擎
 	r := gin.New()
-	// 增加自定义 Logger 和 Recovery 中间件
-	r.Use(customLogger(), gin.Recovery())
+	// 链路追踪、请求ID、请求日志与指标中间件，以及 Recovery 中间件
+	r.Use(otelgin.Middleware(viper.GetString("TRACING.SERVICE_NAME"), otelgin.WithFilter(func(r *http.Request) bool {
+		return !probePaths[r.URL.Path]
+	})), requestLogger(), gin.Recovery())
 	// 注册路由
 	routes.SetupRoutes(r)
 	// 在本地的SERVER_PORT端口启动HTTP服务器
 	port := viper.GetString("SERVER_PORT") // 从viper中读取配置
-	log.Println("Server is running on port " + port)
+	slog.Info("Server is running", "port", port, "version", util.Version)
+
+	// 创建共享数据库连接池，任务历史、分布式锁与各任务共用
+	if err := util.InitDB(); err != nil {
+		fatal("Error initializing databases", err)
+	}
+
+	// 注册后台任务，定时任务与API共用
+	util.RegisterBuiltinJobs()
+	// 任务执行历史写入pg_struct，失败不影响服务启动
+	if err := util.InitJobHistory(); err != nil {
+		slog.Warn("Job history disabled", "error", err)
+	}
+	// Dify网关，配置错误时调用Dify的任务报错
+	if err := util.InitDify(); err != nil {
+		slog.Error("Error initializing Dify gateway", "error", err)
+	}
+	// Dify用量写入pg_struct，失败时只记录指标
+	if err := util.InitDifyUsage(); err != nil {
+		slog.Error("Error initializing Dify usage", "error", err)
+	}
+
+	// 分布式锁与主节点选举，需在定时任务之前初始化
+	if err := util.InitLocker(); err != nil {
+		fatal("Error initializing locker", err)
+	}
 
 	// 初始化定时任务
 	if err := util.InitCron(); err != nil {
-		log.Fatalf("Error initializing cron: %s", err)
+		fatal("Error initializing cron", err)
 	}
 
-
 	// 暴露Prometheus指标
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	r.Run(":" + port)
+	if err := r.Run(":" + port); err != nil {
+		fatal("Server stopped", err)
+	}
+}
+
+// fatal 记录错误日志后退出
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	flushTracing()
+	os.Exit(1)
+}
+
+// shutdownTracing 导出缓冲中的span，initTracing前为空操作
+var shutdownTracing = func(context.Context) error { return nil }
+
+// initTracing 初始化链路追踪，stdout导出器写到w
+func initTracing(w io.Writer) {
+	shutdown, err := util.InitTracing(w)
+	if err != nil {
+		fatal("Error initializing tracing", err)
+	}
+	shutdownTracing = shutdown
+}
+
+// flushTracing 退出前导出尚未发送的span
+func flushTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+}
+
+// flushTracingOnSignal 收到SIGINT/SIGTERM(如服务重启)时导出span后退出
+func flushTracingOnSignal() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	slog.Info("Server stopping")
+	flushTracing()
+	os.Exit(0)
 }
 
-// 自定义 Logger 中间件
-func customLogger() gin.HandlerFunc {
+// probePaths 指标采集与探活请求，不记录请求日志和trace
+var probePaths = map[string]bool{
+	"/metrics": true,
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// requestIDHeader 请求ID的HTTP头，上游未传入时自动生成
+const requestIDHeader = "X-Request-ID"
+
+// requestLogger 为每个请求分配请求ID并写入响应头和请求ctx，日志、后台任务、短信通知据此关联；
+// 同时记录请求指标和结构化请求日志
+func requestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 开始时间
 		start := time.Now()
 
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = util.NewRequestID()
+		}
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(util.WithRequestID(c.Request.Context(), id))
+
 		// 处理请求
 		c.Next()
 
 		// 结束时间
 		duration := time.Since(start)
 
-		// 记录请求指标
-		httpRequestsTotal.With(prometheus.Labels{
+		// 记录请求指标，未匹配路由的请求统一归为unmatched
+		path := c.FullPath()
+		if path == "" {
+			path = "unmatched"
+		}
+		labels := prometheus.Labels{
 			"method": c.Request.Method,
-			"path":   c.Request.URL.Path,
+			"path":   path,
 			"status": fmt.Sprintf("%d", c.Writer.Status()),
-		}).Inc()
+		}
+		httpRequestsTotal.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(duration.Seconds())
 
-		// 跳过指标记录的s请求
-		if c.Request.URL.Path == "/metrics" || c.Request.URL.Path == "/health" {
+		// 跳过指标与探活请求的日志
+		if probePaths[c.Request.URL.Path] {
 			return
 		}
 
-		// 日志格式
-		log.Printf("[webhook] %s %s %d %s", c.Request.Method, c.Request.URL.Path, c.Writer.Status(), duration)
+		// 请求日志，request_id由ctx自动带上
+		slog.InfoContext(c.Request.Context(), "http request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", path,
+			"status", c.Writer.Status(),
+			"duration", duration,
+			"client_ip", c.ClientIP(),
+		)
 
 	}
 }
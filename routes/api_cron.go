@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"net/http"
+
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handleListCron 列出定时任务配置及下次/上次执行时间
+func handleListCron(c *gin.Context) {
+	if util.Cron == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "scheduler is not initialized",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"entries": util.Cron.Entries(),
+	})
+}
+
+// handleRunCronJob 立即触发一次指定任务
+func handleRunCronJob(c *gin.Context) {
+	if util.Cron == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "scheduler is not initialized",
+		})
+		return
+	}
+	job, err := util.Cron.RunNow(c.Param("job"), util.JobOptions{
+		RequestID: util.RequestIDFromContext(c.Request.Context()),
+		TraceLink: trace.SpanContextFromContext(c.Request.Context()),
+	})
+	respondJobSubmitted(c, c.Param("job"), job, err)
+}
@@ -1,18 +1,32 @@
 package routes
 
 import (
-	"go-sms/util"
 	"net/http"
+	"strconv"
+
+	"go-sms/util"
 
 	"github.com/gin-gonic/gin"
 )
 
-// handleRoot 处理根路径的请求
+// handleDelMysql 提交DelMysql任务，与定时任务共用任务管理器避免重叠，删除计划与结果通过status_url查询。
+// dry_run=true时只生成删除计划；backup=none/table/file指定删除前的备份方式
 func handleDelMysql(c *gin.Context) {
-
-	util.DelMysql()
-	// 在这里可以对读取到的数据进行处理，比如解析JSON格式数据等，目前只是简单打印
-	c.JSON(http.StatusOK, gin.H{
-		"message": "DelMysql",
-	})
+	params := map[string]string{}
+	if dryRun, _ := strconv.ParseBool(c.Query("dry_run")); dryRun {
+		params["dry_run"] = "true"
+	}
+	if backup := c.Query("backup"); backup != "" {
+		switch backup {
+		case util.DelMysqlBackupNone, util.DelMysqlBackupTable, util.DelMysqlBackupFile:
+			params["backup"] = backup
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "backup只能为none、table或file",
+			})
+			return
+		}
+	}
+	submitJob(c, util.JobDelMysql, params)
 }
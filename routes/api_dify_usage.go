@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleDifyUsage 统计dify_usage中的token用量。date为单日(YYYY-MM-DD)，或from、to指定[from, to)，
+// 默认为当天；job_type、job_id、app、encounter_id、stage过滤，group_by为逗号分隔的分组维度，
+// 如昨天ProcessMZ按fsjd阶段: ?date=2025-09-01&job_type=process_mz&group_by=stage
+func handleDifyUsage(c *gin.Context) {
+	if util.DifyUsages == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "dify usage is not enabled",
+		})
+		return
+	}
+
+	loc, err := util.DifyUsageLocation()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	from, to, err := usageRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	var groupBy []string
+	for _, g := range strings.Split(c.Query("group_by"), ",") {
+		if g = strings.TrimSpace(g); g == "" {
+			continue
+		}
+		if !util.ValidDifyUsageGroup(g) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "group_by只能为job_type/job_id/app/encounter_id/stage/day: " + g,
+			})
+			return
+		}
+		groupBy = append(groupBy, g)
+	}
+
+	rows, err := util.DifyUsages.Summary(c.Request.Context(), util.DifyUsageQuery{
+		From:        from,
+		To:          to,
+		JobType:     c.Query("job_type"),
+		JobID:       c.Query("job_id"),
+		App:         c.Query("app"),
+		EncounterID: c.Query("encounter_id"),
+		Stage:       c.Query("stage"),
+		GroupBy:     groupBy,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"to":       to,
+		"group_by": groupBy,
+		"rows":     rows,
+	})
+}
+
+var errInvalidUsageRange = errors.New("from必须早于to")
+
+// usageRange 解析统计时间范围，日期按DIFY.USAGE_TIMEZONE(与day分组相同)，to为日期时包含当天
+func usageRange(c *gin.Context, loc *time.Location) (time.Time, time.Time, error) {
+	if date := c.Query("date"); date != "" {
+		day, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		return day, day.AddDate(0, 0, 1), nil
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1)
+	var err error
+	if s := c.Query("from"); s != "" {
+		if from, err = parseUsageTime(s, false, loc); err != nil {
+			return from, to, err
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if to, err = parseUsageTime(s, true, loc); err != nil {
+			return from, to, err
+		}
+	}
+	if !from.Before(to) {
+		return from, to, errInvalidUsageRange
+	}
+	return from, to, nil
+}
+
+// parseUsageTime 支持RFC3339与日期，endOfDay为true时日期取次日零点
+func parseUsageTime(s string, endOfDay bool, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return day, err
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
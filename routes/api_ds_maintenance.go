@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"strconv"
+
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleDSMaintenance 异步执行DolphinScheduler元数据维护，dry_run=true时只统计待删除行数，
+// 结果通过/api/jobs/:id的result查看
+func handleDSMaintenance(c *gin.Context) {
+	params := map[string]string{}
+	if dryRun, _ := strconv.ParseBool(c.Query("dry_run")); dryRun {
+		params["dry_run"] = "true"
+	}
+	submitJob(c, util.JobDSMaintenance, params)
+}
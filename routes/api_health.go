@@ -1,8 +1,11 @@
 package routes
 
 import (
-	"github.com/gin-gonic/gin"
 	"net/http"
+
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
 )
 
 // handleHealth 处理健康检查请求
@@ -10,4 +13,26 @@ func handleHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "UP",
 	})
-}
\ No newline at end of file
+}
+
+// handleHealthz 存活检查，只要进程能处理请求即返回200
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "UP",
+	})
+}
+
+// handleReadyz 就绪检查，必需依赖不可达时返回503
+func handleReadyz(c *gin.Context) {
+	report := util.Health.Check(c.Request.Context())
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// handleVersion 返回版本与构建信息
+func handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, util.GetBuildInfo())
+}
@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// submitJob 通过任务管理器提交后台任务，返回任务ID及状态查询地址
+func submitJob(c *gin.Context, jobType string, params map[string]string) {
+	job, err := util.Jobs.Submit(jobType, util.JobOptions{
+		Trigger:   util.TriggerAPI,
+		Params:    params,
+		RequestID: util.RequestIDFromContext(c.Request.Context()),
+		TraceLink: trace.SpanContextFromContext(c.Request.Context()),
+	})
+	respondJobSubmitted(c, jobType, job, err)
+}
+
+// respondJobSubmitted 根据任务提交结果返回202/404/409
+func respondJobSubmitted(c *gin.Context, jobType string, job *util.Job, err error) {
+	var conflict *util.JobConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success":        false,
+			"error":          err.Error(),
+			"running_job_id": conflict.RunningJobID,
+		})
+		return
+	}
+	if errors.Is(err, util.ErrUnknownJob) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":    true,
+		"message":    jobType + "请求已接收，正在处理中",
+		"job_id":     job.ID,
+		"status_url": util.StatusURL(job.ID),
+	})
+}
+
+// handleListJobs 列出内存中的任务
+func handleListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": util.Jobs.List(),
+	})
+}
+
+// handleGetJob 查询任务状态、进度、计数和错误
+func handleGetJob(c *gin.Context) {
+	job, ok := util.Jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job.View())
+}
+
+// handleCancelJob 取消任务
+func handleCancelJob(c *gin.Context) {
+	if !util.Jobs.Cancel(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+	job, _ := util.Jobs.Get(c.Param("id"))
+	c.JSON(http.StatusAccepted, job.View())
+}
+
+// handleJobHistory 分页查询job_runs中的任务执行历史
+func handleJobHistory(c *gin.Context) {
+	if util.History == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "job history is not enabled",
+		})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	q := util.JobRunQuery{
+		JobType:  c.Query("job_type"),
+		Status:   c.Query("status"),
+		Trigger:  c.Query("trigger"),
+		Page:     page,
+		PageSize: pageSize,
+	}
+	runs, total, err := util.History.List(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"runs":      runs,
+	})
+}
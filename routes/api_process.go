@@ -1,118 +1,22 @@
 package routes
 
 import (
-	"net/http"
 	"go-sms/util"
-	"log"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // handleProcessVisits 处理触发ProcessVisits的请求
 func handleProcessVisits(c *gin.Context) {
-	if c.Request.Method != "POST" {
-		c.JSON(http.StatusMethodNotAllowed, gin.H{
-			"error": "Only POST requests are allowed",
-		})
-		return
-	}
-
-	// 异步处理请求
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("Recovered from panic in ProcessVisits: %v", r)
-			}
-		}()
-
-		startTime := time.Now()
-		log.Println("开始处理ProcessVisits...")
-		
-		if err := util.ProcessVisits(); err != nil {
-			log.Printf("ProcessVisits执行出错: %v", err)
-		} else {
-			duration := time.Since(startTime)
-			log.Printf("ProcessVisits执行完成，耗时: %v", duration)
-		}
-	}()
-
-	// 返回一个JSON格式的响应，表示已接收请求
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "ProcessVisits请求已接收，正在处理中",
-	})
+	submitJob(c, util.JobProcessVisits, nil)
 }
 
 // handleProcessMZMain 处理触发ProcessMZMain的请求
 func handleProcessMZMain(c *gin.Context) {
-	if c.Request.Method != "POST" {
-		c.JSON(http.StatusMethodNotAllowed, gin.H{
-			"error": "Only POST requests are allowed",
-		})
-		return
-	}
-
-	// 异步处理请求
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("Recovered from panic in ProcessMZMain: %v", r)
-			}
-		}()
-
-		startTime := time.Now()
-		log.Println("开始处理ProcessMZMain...")
-		
-		if err := util.ProcessMZMain(); err != nil {
-			log.Printf("ProcessMZMain执行出错: %v", err)
-		} else {
-			duration := time.Since(startTime)
-			log.Printf("ProcessMZMain执行完成，耗时: %v", duration)
-		}
-	}()
-
-	// 返回一个JSON格式的响应，表示已接收请求
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "ProcessMZMain请求已接收，正在处理中",
-	})
+	submitJob(c, util.JobProcessMZ, nil)
 }
 
 // handleValidatePatientData 处理患者数据校验请求
 func handleValidatePatientData(c *gin.Context) {
-	if c.Request.Method != "POST" {
-		c.JSON(http.StatusMethodNotAllowed, gin.H{
-			"error": "Only POST requests are allowed",
-		})
-		return
-	}
-
-	// 异步处理请求
-	go func() {
-		// 捕获可能的panic
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("处理校验患者数据时发生panic: %v", r)
-			}
-		}()
-
-		startTime := time.Now()
-		log.Println("开始校验患者数据")
-
-		// 调用校验函数
-		err := util.ValidatePatientData()
-		if err != nil {
-			log.Printf("校验患者数据失败: %v", err)
-		}
-
-		duration := time.Since(startTime)
-		log.Printf("患者数据校验完成，耗时: %v", duration)
-	}()
-
-	// 返回响应
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "患者数据校验任务已启动，正在后台处理",
-	})
-}
\ No newline at end of file
+	submitJob(c, util.JobValidatePatient, nil)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"strconv"
+
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleSchemaSync 异步同步mysql_src表结构到postgres_tgt，dry_run=true时只生成DDL，tables只同步指定的表，
+// DDL通过/api/jobs/:id的result查看
+func handleSchemaSync(c *gin.Context) {
+	params := map[string]string{}
+	if tables := syncTablesQuery(c); tables != "" {
+		params["tables"] = tables
+	}
+	if dryRun, _ := strconv.ParseBool(c.Query("dry_run")); dryRun {
+		params["dry_run"] = "true"
+	}
+	submitJob(c, util.JobSchemaSync, params)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"go-sms/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleSyncVerify 异步校验mysql_src与postgres_tgt中已同步的表，不执行同步。
+// verify默认为checksum，repair=true时重新同步不一致的分块，结果通过/api/jobs/:id的result查看
+func handleSyncVerify(c *gin.Context) {
+	params, ok := syncVerifyQuery(c)
+	if !ok {
+		return
+	}
+	submitJob(c, util.JobSyncVerify, params)
+}
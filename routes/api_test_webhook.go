@@ -1,12 +1,13 @@
 package routes
 
 import (
+	"context"
 	"net/http"
 
 	"encoding/json"
 	"fmt"
 	"go-sms/util"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,10 +29,12 @@ func handleWebhook2(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+
 	// 获取请求体中的数据
 	body, err := c.GetRawData()
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		slog.WarnContext(ctx, "Error reading request body", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Request body is empty or invalid",
 		})
@@ -54,8 +57,8 @@ func handleWebhook2(c *gin.Context) {
 		return
 	}
 
-	// 在这里可以对读取到的数据进行处理，比如解析JSON格式数据等，目前只是简单打印
-	log.Println(string(body))
+	// 原始告警内容只在debug级别输出
+	slog.DebugContext(ctx, "收到告警", "body", string(body))
 
 	smsPlatform := util.CallSmsPlatform{
 		URL:        viper.GetString("SMS_PLATFORM_URL"), // 直接从viper中读取配置
@@ -66,10 +69,10 @@ func handleWebhook2(c *gin.Context) {
 	var alert util.ProAlert
 	err = json.Unmarshal(body, &alert)
 	if err != nil {
-		fmt.Println("解析JSON出错:", err)
+		slog.WarnContext(ctx, "解析JSON出错", "error", err)
 		return
 	}
-	log.Println(alert.CommonAnnotations["summary"])
+	slog.InfoContext(ctx, "告警摘要", "status", alert.Status, "summary", alert.CommonAnnotations["summary"])
 
 	// 获取配置文件中的多个手机号
 	phoneNumbers := viper.GetStringSlice("PHONE_NUMBERS") // 直接从viper中读取配置
@@ -77,19 +80,21 @@ func handleWebhook2(c *gin.Context) {
 	// 获取等待间隔配置项
 	smsSendInterval := viper.GetInt("SMS_SEND_INTERVAL") // 从viper中读取配置
 
+	// 短信异步发送，保留请求ID但不随请求结束而取消
+	smsCtx := context.WithoutCancel(ctx)
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("Recovered from panic in goroutine: %v", r)
+				slog.ErrorContext(smsCtx, "Recovered from panic in goroutine", "panic", r)
 			}
 		}()
 
 		// 异步发送短信给每个手机号
 		for _, phoneNumber := range phoneNumbers {
-			if err := sendSms2(smsPlatform, phoneNumber, alert.CommonAnnotations["summary"]); err != nil {
-				log.Printf("Failed to send SMS to %s: %v", phoneNumber, err)
+			if err := sendSms2(smsCtx, smsPlatform, phoneNumber, alert.CommonAnnotations["summary"]); err != nil {
+				slog.ErrorContext(smsCtx, "Failed to send SMS", "phone", phoneNumber, "error", err)
 			} else {
-				log.Printf("SMS sent successfully to %s", phoneNumber)
+				slog.InfoContext(smsCtx, "SMS sent successfully", "phone", phoneNumber)
 			}
 			// 增加等待间隔
 			time.Sleep(time.Duration(smsSendInterval) * time.Second)
@@ -102,11 +107,9 @@ func handleWebhook2(c *gin.Context) {
 	})
 }
 
-func sendSms2(platform util.CallSmsPlatform, phoneNumber, message string) error {
+func sendSms2(ctx context.Context, platform util.CallSmsPlatform, phoneNumber, message string) error {
 	if true {
-		log.Println("发送短信")
-		log.Println("手机号:", phoneNumber)
-		log.Println("消息:", message)
+		slog.InfoContext(ctx, "发送短信", "phone", phoneNumber, "content", util.Redact(message))
 		return nil
 	}
 	return fmt.Errorf("failed to send SMS")
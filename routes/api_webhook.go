@@ -1,15 +1,13 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"go-sms/util"
-	"log"
+	"log/slog"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
 )
 
 // handleWebhook是使用Gin框架处理Webhook请求的函数，它接收一个*gin.Context作为参数
@@ -21,10 +19,12 @@ func handleWebhook(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+
 	// 获取请求体中的数据
 	body, err := c.GetRawData()
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		slog.WarnContext(ctx, "Error reading request body", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Request body is empty or invalid",
 		})
@@ -47,46 +47,27 @@ func handleWebhook(c *gin.Context) {
 		return
 	}
 
-	// 在这里可以对读取到的数据进行处理，比如解析JSON格式数据等，目前只是简单打印
-	log.Println(string(body))
-
-	smsPlatform := util.CallSmsPlatform{
-		URL:        viper.GetString("SMS_PLATFORM_URL"), // 直接从viper中读取配置
-		SOAPAction: viper.GetString("SOAP_ACTION"),      // 直接从viper中读取配置
-	}
+	// 原始告警内容只在debug级别输出
+	slog.DebugContext(ctx, "收到告警", "body", string(body))
 
 	// 解析JSON数据到结构体实例
 	var alert util.ProAlert
 	err = json.Unmarshal(body, &alert)
 	if err != nil {
-		fmt.Println("解析JSON出错:", err)
+		slog.WarnContext(ctx, "解析JSON出错", "error", err)
 		return
 	}
-	log.Println(alert.CommonAnnotations["summary"])
-
-	// 获取配置文件中的多个手机号
-	phoneNumbers := viper.GetStringSlice("PHONE_NUMBERS") // 直接从viper中读取配置
-
-	// 获取等待间隔配置项
-	smsSendInterval := viper.GetInt("SMS_SEND_INTERVAL") // 从viper中读取配置
+	slog.InfoContext(ctx, "告警摘要", "status", alert.Status, "summary", alert.CommonAnnotations["summary"])
 
+	// 短信异步发送，保留请求ID但不随请求结束而取消
+	smsCtx := context.WithoutCancel(ctx)
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("Recovered from panic in goroutine: %v", r)
+				slog.ErrorContext(smsCtx, "Recovered from panic in goroutine", "panic", r)
 			}
 		}()
-
-		// 异步发送短信给每个手机号
-		for _, phoneNumber := range phoneNumbers {
-			if err := sendSms(smsPlatform, phoneNumber, alert.CommonAnnotations["summary"]); err != nil {
-				log.Printf("Failed to send SMS to %s: %v", phoneNumber, err)
-			} else {
-				log.Printf("SMS sent successfully to %s", phoneNumber)
-			}
-			// 增加等待间隔
-			time.Sleep(time.Duration(smsSendInterval) * time.Second)
-		}
+		util.NotifyPhones(smsCtx, "prometheus", alert.CommonAnnotations["summary"])
 	}()
 
 	// 返回一个JSON格式的响应，表示成功接收
@@ -104,10 +85,3 @@ func checkIdempotency(c *gin.Context) bool {
 	// 实现幂等性检查逻辑
 	return true
 }
-
-func sendSms(platform util.CallSmsPlatform, phoneNumber, message string) error {
-	if platform.SendSms(phoneNumber, message) {
-		return nil
-	}
-	return fmt.Errorf("failed to send SMS")
-}
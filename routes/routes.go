@@ -10,11 +10,29 @@ func SetupRoutes(r *gin.Engine) {
 	r.POST("/webhook", handleWebhook)
 	r.GET("/", handleRoot)          // 修改: 调用handleRoot函数
 	r.POST("/health", handleHealth) // 修改: 调用handleHealth函数
+	r.GET("/health", handleHealth)
+	// 存活、就绪检查与版本信息，供负载均衡和systemd watchdog使用
+	r.GET("/healthz", handleHealthz)
+	r.GET("/readyz", handleReadyz)
+	r.GET("/version", handleVersion)
 	r.POST("/test/webhook", handleTestWebhook)
 	r.POST("/del/mysql", handleDelMysql)
+	r.POST("/api/ds/maintenance", handleDSMaintenance)
 	r.POST("/seatunnel/mysql/pg", handleSeatunnelMysqlPg)
+	r.POST("/api/sync/schema", handleSchemaSync)
+	r.POST("/api/sync/verify", handleSyncVerify)
 	// 注册处理ProcessVisits和ProcessMZMain的API路由
 	r.POST("/api/process/visits", handleProcessVisits)
 	r.POST("/api/process/mz", handleProcessMZMain)
 	r.POST("/api/validate/patient", handleValidatePatientData)
+	// 后台任务状态查询与取消
+	r.GET("/api/jobs", handleListJobs)
+	r.GET("/api/jobs/history", handleJobHistory)
+	r.GET("/api/jobs/:id", handleGetJob)
+	r.DELETE("/api/jobs/:id", handleCancelJob)
+	// Dify token用量统计
+	r.GET("/api/dify/usage", handleDifyUsage)
+	// 定时任务查看与立即触发
+	r.GET("/api/cron", handleListCron)
+	r.POST("/api/cron/:job/run", handleRunCronJob)
 }
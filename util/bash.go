@@ -1,16 +1,18 @@
 package util
 
 import (
+	"context"
+	"log/slog"
 	"os/exec"
-	"log"
+	"strings"
 )
 
-func DelHistory() error {
+func DelHistory(ctx context.Context) error {
 	// 使用更简单的命令清除历史记录，并捕获输出以便更好地调试
-	cmd := exec.Command("bash", "-c", "cat /dev/null > ~/.bash_history 2>/dev/null || true")
+	cmd := exec.CommandContext(ctx, "bash", "-c", "cat /dev/null > ~/.bash_history 2>/dev/null || true")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Printf("failed to clear history: %v, output: %s", err, string(out))
+		slog.ErrorContext(ctx, "failed to clear history", "error", err, "output", string(out))
 	}
 	return nil
 }
@@ -19,21 +21,21 @@ func LsTest() error {
 	cmd := exec.Command("ls", "-l", "/var/log/")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-        log.Printf("combined out:\n%s\n", string(out))
-		log.Fatalf("cmd.Run() failed with %s\n", err)
+		slog.Error("cmd.Run() failed", "error", err, "output", string(out))
+		return err
 	}
-	log.Printf("combined out:\n%s\n", string(out))
+	slog.Info("combined out", "output", string(out))
 	return nil
 }
-	
+
 // 获取本机ip的函数
 func GetLocalIP() (string, error) {
 	cmd := exec.Command("bash", "-c", "hostname -I | awk '{print $1}'")
 	out, err := cmd.Output()
 	if err != nil {
-		log.Printf("failed to get local IP: %v", err)
+		slog.Error("failed to get local IP", "error", err)
 		return "", err
 	}
-	log.Printf("local IP: %s", string(out))
+	slog.Info("local IP", "ip", strings.TrimSpace(string(out)))
 	return string(out), nil
 }
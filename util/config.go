@@ -2,7 +2,8 @@
 package util
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/viper"
@@ -15,17 +16,74 @@ func InitConfig() error {
 	// 获取当前工作目录并设置为配置文件路径
 	wd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Error getting working directory, %s", err)
+		return fmt.Errorf("error getting working directory: %w", err)
 	}
 	viper.AddConfigPath(wd)
 
-	log.Println("Working directory:", wd)
+	slog.Info("Working directory", "path", wd)
+
+	// 默认配置
+	viper.SetDefault("JOB_CONFLICT_POLICY", "reject") // 同类型任务运行中时: reject拒绝 / queue排队
+	viper.SetDefault("JOB_HISTORY.ENABLED", true)     // 任务执行历史写入pg_struct库的job_runs表
+	viper.SetDefault("LOCK.DRIVER", "none")           // 分布式锁: none单实例 / postgres(pg_struct) / mysql(DB)
+	viper.SetDefault("LOCK.PREFIX", "webhook")
+	viper.SetDefault("LOCK.MAX_OPEN_CONNS", 20)  // 锁的独立连接池上限，每个持有的锁占用一个连接，不占用业务连接池
+	viper.SetDefault("LOG.LEVEL", "info")        // debug / info / warn / error
+	viper.SetDefault("LOG.FORMAT", "text")       // text / json
+	viper.SetDefault("LOG.SENSITIVE", false)     // 是否在日志中输出患者信息、短信内容等敏感文本
+	viper.SetDefault("LOG.OUTPUT", "stdout")     // stdout / stderr，命令行运维命令固定使用stderr
+	viper.SetDefault("TRACING.EXPORTER", "none") // 链路追踪: none / stdout / otlp
+	viper.SetDefault("TRACING.SERVICE_NAME", "webhook")
+	viper.SetDefault("TRACING.SAMPLE_RATIO", 1.0)
+	viper.SetDefault("HEALTH.TIMEOUT", "3s")       // /readyz 单个依赖检查超时
+	viper.SetDefault("HEALTH.CACHE_TTL", "10s")    // /readyz 检查结果缓存时间
+	viper.SetDefault("DB_POOL.MAX_OPEN_CONNS", 20) // 各命名连接池默认参数，可在连接配置下单独覆盖
+	viper.SetDefault("DB_POOL.MAX_IDLE_CONNS", 5)
+	viper.SetDefault("DB_POOL.CONN_MAX_LIFETIME", "30m")
+	viper.SetDefault("DB_POOL.CONN_MAX_IDLE_TIME", "5m")
+	viper.SetDefault("DB_POOL.PING_INTERVAL", "30s")
+	viper.SetDefault("DB_OPTIONS.SSLMODE", "disable") // 各连接默认选项，可在连接配置下单独覆盖
+	viper.SetDefault("DB_OPTIONS.CONNECT_TIMEOUT", "10s")
+	viper.SetDefault("DB_OPTIONS.CHARSET", "utf8mb4")
+	viper.SetDefault("DEL_MYSQL.BACKUP", "table")      // DelMysql删除前备份: none / table(备份表) / file(JSONL文件)
+	viper.SetDefault("DEL_MYSQL.BACKUP_DIR", "backup") // file备份目录
+	viper.SetDefault("SYNC.BACKEND", "native")         // MySQL到Postgres同步: native(内置COPY) / seatunnel
+	viper.SetDefault("SYNC.WORKERS", 4)                // 并行同步的表数
+	viper.SetDefault("SYNC.CHUNK_SIZE", 5000)          // native按主键分块读取的行数
+	viper.SetDefault("SYNC.SCHEMA_SYNC", true)         // 同步数据前按源表结构创建或补充目标表
+	viper.SetDefault("SYNC.MODE", "full")              // full / incremental
+	viper.SetDefault("SYNC.WATERMARK_COLUMNS", []string{"update_time", "updated_at", "modify_time", "gmt_modified"})
+	viper.SetDefault("SYNC.WATERMARK_LAG", "5m")                  // 时间水位每次回退的窗口，覆盖长事务晚提交的行
+	viper.SetDefault("SYNC.VERIFY", "count")                      // 同步后校验: none / count / checksum
+	viper.SetDefault("SYNC.VERIFY_REPAIR", false)                 // checksum校验不一致时重新同步不一致的分块
+	viper.SetDefault("SYNC.VERIFY_REPAIR_TABLE_MAX_ROWS", 100000) // 不分块的表(主键不全为整数列)整表修复的最大行数
+	viper.SetDefault("SEATUNNEL.COMMAND", "/data/seatunnel/bin/seatunnel.sh")
+	viper.SetDefault("SEATUNNEL.MASTER", "local")                 // local / cluster
+	viper.SetDefault("SEATUNNEL.JOB_DIR", "/data/seatunnel/job")  // 生成的作业配置目录，配置为空时使用系统临时目录
+	viper.SetDefault("SEATUNNEL.ENV_CREDENTIALS", true)           // 密码通过环境变量传入，不写入作业配置文件
+	viper.SetDefault("SEATUNNEL.LOG_DIR", "/data/seatunnel/logs") // 单表作业输出日志目录
+	viper.SetDefault("SEATUNNEL.TABLE_TIMEOUT", "2h")             // 单表作业超时，超时后终止进程组，0为不限制
+	viper.SetDefault("SEATUNNEL.KILL_GRACE", "30s")               // 终止时SIGTERM到SIGKILL的等待时间
+	viper.SetDefault("DIFY.RETRY_BASE", "1s")                     // Dify重试退避的初始等待，按2的指数增长并加随机抖动
+	viper.SetDefault("DIFY.RETRY_MAX", "30s")                     // Dify重试退避的最长等待
+	viper.SetDefault("DIFY.BREAKER_FAILURES", 5)                  // 单个Dify应用连续失败多少次后熔断，0为不熔断
+	viper.SetDefault("DIFY.BREAKER_COOLDOWN", "30s")              // 熔断后多久放行一次探测调用
+	viper.SetDefault("DIFY.STREAMING_APPS", []string{})           // 使用streaming模式调用的应用，避免长时间blocking调用被网关超时断开
+	viper.SetDefault("DIFY.STREAM_IDLE_TIMEOUT", "60s")           // streaming响应超过该时间没有任何事件(含ping)视为超时
+	viper.SetDefault("DIFY.USAGE_ENABLED", true)                  // Dify调用的token用量写入pg_struct库的dify_usage表
+	viper.SetDefault("DIFY.USAGE_TIMEZONE", "Asia/Shanghai")      // 用量统计按天划分的时区，date/from/to的日期与day分组共用
+	viper.SetDefault("MZ.RECONCILE", "partial")                   // 门诊指标结果对账: partial(接受匹配的指标，只重新请求缺少的) / strict(不一致时重新请求整批)
+	viper.SetDefault("MZ.MAX_CALLS", 2)                           // 每批指标最多调用工作流的次数，含补充请求缺少指标的调用
+	viper.SetDefault("MZ.STORE_DISCREPANCIES", true)              // 不一致的指标写入pg_struct库的mz_indicator_discrepancy表
+	viper.SetDefault("DS_ALERT.STATES", []int{6})                 // 告警的工作流实例状态，6为失败
+	viper.SetDefault("DS_ALERT.LOOKBACK", "1h")                   // 首次运行时回溯的时间
+	viper.SetDefault("DS_ALERT.BATCH_SIZE", 100)                  // 每次轮询最多处理的实例数
 
 	// 读取配置文件
 	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
+		return fmt.Errorf("error reading config file: %w", err)
 	}
-	log.Println("Config file loaded successfully")
+	slog.Info("Config file loaded successfully")
 
 	// 强制覆盖配置
 	viper.Set("SERVER_PORT", "8083") //sfy默认必须是8080
@@ -68,7 +126,7 @@ func InitConfig() error {
 	viper.Set("DIFY_WORKFLOW_API_KEY", "app-ZwR4asPswIrVVrjn0BrsfLAL")
 	viper.Set("DIFY_WORKFLOW_API_KEY_MZ", "app-i9aetroXzOB7TJUPOYJOaGhC")
 	viper.Set("DIFY_API_USER", "user1")
-	viper.Set("DIFY_API_TIMEOUT", 3000)
+	viper.Set("DIFY_API_TIMEOUT", 3000) // 单次调用超时(秒)
 	viper.Set("DIFY_API_MAX_RETRY", 3)
 
 	return nil
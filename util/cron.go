@@ -1,68 +1,182 @@
 package util
 
 import (
-	"github.com/robfig/cron/v3"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
 	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
 )
 
+// JobSchedule 单个任务的定时配置，对应配置文件jobs.<name>
+type JobSchedule struct {
+	Schedule string        `mapstructure:"schedule"` // cron表达式，秒字段可选，支持@every/@daily
+	Enabled  bool          `mapstructure:"enabled"`
+	Timezone string        `mapstructure:"timezone"` // 为空时使用CRON_TIMEZONE
+	Timeout  time.Duration `mapstructure:"timeout"`  // 单次执行超时，0不限制
+	Jitter   time.Duration `mapstructure:"jitter"`   // 触发后随机延迟[0, jitter)
+}
+
+// defaultJobSchedules 未配置时的默认值，与原硬编码的定时任务一致
+var defaultJobSchedules = map[string]JobSchedule{
+	JobDelMysql:         {Schedule: "@every 24h", Enabled: true},
+	JobDelHistory:       {Schedule: "@every 5m", Enabled: true},
+	JobProcessVisits:    {Schedule: "@every 1h", Enabled: true},
+	JobValidatePatient:  {Schedule: "@every 1h", Enabled: true},
+	JobProcessMZ:        {Schedule: "0 2 * * *", Enabled: false},
+	JobSeatunnelMysqlPg: {Schedule: "0 1 * * *", Enabled: false},
+	JobDSMaintenance:    {Schedule: "0 3 * * *", Enabled: false},
+	JobDSAlert:          {Schedule: "@every 1m", Enabled: false},
+}
+
+// cronParser 支持可选的秒字段和@描述符
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// CronEntry 定时任务信息，用于API返回
+type CronEntry struct {
+	Job      string     `json:"job"`
+	Schedule string     `json:"schedule"`
+	Enabled  bool       `json:"enabled"`
+	Timezone string     `json:"timezone,omitempty"`
+	Timeout  string     `json:"timeout,omitempty"`
+	Jitter   string     `json:"jitter,omitempty"`
+	Next     *time.Time `json:"next,omitempty"`
+	Prev     *time.Time `json:"prev,omitempty"`
+}
+
+// Scheduler 按配置调度已注册的任务，所有执行都经过任务管理器
+type Scheduler struct {
+	cron     *cron.Cron
+	configs  map[string]JobSchedule
+	entryIDs map[string]cron.EntryID
+}
+
+// Cron 全局调度器，InitCron后可用
+var Cron *Scheduler
 
 func InitCron() error {
-	// 启动定时任务
-	c := cron.New()
-	
-	// 每天清理MySQL数据
-	c.AddFunc("@every 1d", func() {
-		log.Println("开始执行DelMysql任务")
-		startTime := time.Now()
-		
-		if err := DelMysql(); err != nil {
-			log.Printf("DelMysql任务执行失败: %v", err)
-		} else {
-			duration := time.Since(startTime)
-			log.Printf("DelMysql任务执行完成，耗时: %v", duration)
+	loc := time.Local
+	if tz := viper.GetString("CRON_TIMEZONE"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("CRON_TIMEZONE无效: %w", err)
+		}
+	}
+
+	s := &Scheduler{
+		cron:     cron.New(cron.WithParser(cronParser), cron.WithLocation(loc)),
+		configs:  make(map[string]JobSchedule),
+		entryIDs: make(map[string]cron.EntryID),
+	}
+
+	// 检查配置中的未知任务
+	for name := range viper.GetStringMap("jobs") {
+		if _, ok := defaultJobSchedules[name]; !ok && !contains(Jobs.Types(), name) {
+			slog.Warn("jobs配置中的任务未注册，已忽略", "job", name)
 		}
-	})
-	
-	// 每5分钟清理历史数据（原每2秒频率过高）
-	c.AddFunc("@every 5m", func() {
-		log.Println("开始执行DelHistory任务")
-		startTime := time.Now()
-		
-		if err := DelHistory(); err != nil {
-			log.Printf("DelHistory任务执行失败: %v", err)
-		} else {
-			duration := time.Since(startTime)
-			log.Printf("DelHistory任务执行完成，耗时: %v", duration)
+	}
+
+	for _, name := range Jobs.Types() {
+		cfg := defaultJobSchedules[name]
+		if err := viper.UnmarshalKey("jobs."+name, &cfg); err != nil {
+			return fmt.Errorf("解析jobs.%s配置失败: %w", name, err)
 		}
-	})
-	
-	// 每1小时处理患者访视记录
-	c.AddFunc("@every 1h", func() {
-		log.Println("开始执行ProcessVisits任务")
-		startTime := time.Now()
-		
-		if err := ProcessVisits(); err != nil {
-			log.Printf("ProcessVisits任务执行失败: %v", err)
-		} else {
-			duration := time.Since(startTime)
-			log.Printf("ProcessVisits任务执行完成，耗时: %v", duration)
+		s.configs[name] = cfg
+		Jobs.SetTimeout(name, cfg.Timeout)
+
+		if !cfg.Enabled || cfg.Schedule == "" {
+			slog.Info("定时任务未启用", "job", name)
+			continue
 		}
-	})
-	
-	// 每1小时校验患者数据（独立定时任务）
-	c.AddFunc("@every 1h", func() {
-		log.Println("开始执行ValidatePatientData任务")
-		startTime := time.Now()
-		
-		if err := ValidatePatientData(); err != nil {
-			log.Printf("ValidatePatientData任务执行失败: %v", err)
-		} else {
-			duration := time.Since(startTime)
-			log.Printf("ValidatePatientData任务执行完成，耗时: %v", duration)
+		spec := cfg.Schedule
+		if cfg.Timezone != "" {
+			spec = "CRON_TZ=" + cfg.Timezone + " " + spec
 		}
-	})
-	
-	c.Start()
+		jobName, jitter := name, cfg.Jitter
+		id, err := s.cron.AddFunc(spec, func() { submitCronJob(jobName, jitter) })
+		if err != nil {
+			return fmt.Errorf("定时任务%s的表达式%q无效: %w", name, spec, err)
+		}
+		s.entryIDs[name] = id
+		slog.Info("定时任务已启用", "job", name, "schedule", spec)
+	}
+
+	s.cron.Start()
+	Cron = s
 	return nil
-}
\ No newline at end of file
+}
+
+// Entries 列出所有已注册任务的定时配置及下次/上次执行时间
+func (s *Scheduler) Entries() []CronEntry {
+	entries := make([]CronEntry, 0, len(s.configs))
+	for name, cfg := range s.configs {
+		e := CronEntry{
+			Job:      name,
+			Schedule: cfg.Schedule,
+			Enabled:  cfg.Enabled,
+			Timezone: cfg.Timezone,
+		}
+		if cfg.Timeout > 0 {
+			e.Timeout = cfg.Timeout.String()
+		}
+		if cfg.Jitter > 0 {
+			e.Jitter = cfg.Jitter.String()
+		}
+		if id, ok := s.entryIDs[name]; ok {
+			entry := s.cron.Entry(id)
+			if !entry.Next.IsZero() {
+				next := entry.Next
+				e.Next = &next
+			}
+			if !entry.Prev.IsZero() {
+				prev := entry.Prev
+				e.Prev = &prev
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Job < entries[b].Job })
+	return entries
+}
+
+// RunNow 立即触发一次任务，不受enabled影响；opts携带触发请求的ID与trace，Trigger固定为api
+func (s *Scheduler) RunNow(name string, opts JobOptions) (*Job, error) {
+	opts.Trigger = TriggerAPI
+	return Jobs.Submit(name, opts)
+}
+
+// submitCronJob 通过任务管理器触发定时任务，与手动触发的同类型任务互斥
+func submitCronJob(jobType string, jitter time.Duration) {
+	// 多实例部署时只有主节点触发定时任务
+	if !Leader.IsLeader() {
+		return
+	}
+	if jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(jitter))))
+	}
+
+	job, err := Jobs.Submit(jobType, JobOptions{Trigger: TriggerCron})
+	var conflict *JobConflictError
+	if errors.As(err, &conflict) {
+		slog.Warn("任务正在运行，跳过本次定时执行", "job_type", jobType, "running_job_id", conflict.RunningJobID)
+		return
+	}
+	if err != nil {
+		slog.Error("任务提交失败", "job_type", jobType, "error", err)
+		return
+	}
+	slog.Info("定时任务已提交", "job_type", jobType, "job_id", job.ID)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/spf13/viper"
+)
+
+// 命名数据库连接
+const (
+	DBDolphinScheduler = "dolphinscheduler" // DolphinScheduler元数据库(默认MySQL，可通过DB.DRIVER改为postgres)，配置项DB.*
+	DBPgStruct         = "pg_struct"        // 结构化数据库(Postgres)
+	DBMySQLSrc         = "mysql_src"        // 同步源库(MySQL)
+	DBPostgresTgt      = "postgres_tgt"     // 同步目标库(Postgres)
+)
+
+// dbDefinitions 命名连接与配置前缀、驱动的对应关系
+var dbDefinitions = []struct {
+	name, prefix, driver string
+}{
+	{DBDolphinScheduler, "DB", "mysql"},
+	{DBPgStruct, "pg_struct", "postgres"},
+	{DBMySQLSrc, "mysql_src", "mysql"},
+	{DBPostgresTgt, "postgres_tgt", "postgres"},
+}
+
+// DBConfig 数据库连接信息、连接选项与连接池参数
+type DBConfig struct {
+	Driver                                 string // mysql / postgres
+	Host, User, Password, Database, Schema string
+	Port                                   int
+
+	SSLMode        string // disable / require / verify-ca / verify-full
+	SSLRootCert    string // CA证书文件，verify-ca/verify-full时使用
+	SSLCert        string // 客户端证书文件
+	SSLKey         string // 客户端私钥文件
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration // 仅MySQL
+	WriteTimeout   time.Duration // 仅MySQL
+	Charset        string        // 仅MySQL
+	Timezone       string        // 会话时区，如Asia/Shanghai
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// GetDBConfig 从viper读取数据库配置，连接选项与连接池参数未单独配置时使用DB_OPTIONS.*、DB_POOL.*
+func GetDBConfig(prefix string) DBConfig {
+	cfg := DBConfig{
+		Host:     viper.GetString(prefix + ".HOST"),
+		User:     viper.GetString(prefix + ".USER"),
+		Password: viper.GetString(prefix + ".PASSWORD"),
+		Database: viper.GetString(prefix + ".DATABASE"),
+		Schema:   viper.GetString(prefix + ".SCHEMA"),
+		Port:     viper.GetInt(prefix + ".PORT"),
+
+		SSLMode:        viper.GetString(dbKey(prefix, "DB_OPTIONS", "SSLMODE")),
+		SSLRootCert:    viper.GetString(dbKey(prefix, "DB_OPTIONS", "SSL_ROOT_CERT")),
+		SSLCert:        viper.GetString(dbKey(prefix, "DB_OPTIONS", "SSL_CERT")),
+		SSLKey:         viper.GetString(dbKey(prefix, "DB_OPTIONS", "SSL_KEY")),
+		ConnectTimeout: viper.GetDuration(dbKey(prefix, "DB_OPTIONS", "CONNECT_TIMEOUT")),
+		ReadTimeout:    viper.GetDuration(dbKey(prefix, "DB_OPTIONS", "READ_TIMEOUT")),
+		WriteTimeout:   viper.GetDuration(dbKey(prefix, "DB_OPTIONS", "WRITE_TIMEOUT")),
+		Charset:        viper.GetString(dbKey(prefix, "DB_OPTIONS", "CHARSET")),
+		Timezone:       viper.GetString(dbKey(prefix, "DB_OPTIONS", "TIMEZONE")),
+
+		MaxOpenConns:    viper.GetInt(dbKey(prefix, "DB_POOL", "MAX_OPEN_CONNS")),
+		MaxIdleConns:    viper.GetInt(dbKey(prefix, "DB_POOL", "MAX_IDLE_CONNS")),
+		ConnMaxLifetime: viper.GetDuration(dbKey(prefix, "DB_POOL", "CONN_MAX_LIFETIME")),
+		ConnMaxIdleTime: viper.GetDuration(dbKey(prefix, "DB_POOL", "CONN_MAX_IDLE_TIME")),
+	}
+	for _, def := range dbDefinitions {
+		if def.prefix == prefix {
+			cfg.Driver = def.driver
+		}
+	}
+	// DolphinScheduler元数据库也可能是Postgres，通过DB.DRIVER指定
+	if driver := viper.GetString(prefix + ".DRIVER"); driver != "" {
+		cfg.Driver = driver
+	}
+	if cfg.Schema == "" && cfg.Driver == "postgres" {
+		cfg.Schema = "public"
+	}
+	if cfg.Port == 0 {
+		switch cfg.Driver {
+		case "mysql":
+			cfg.Port = 3306
+		case "postgres":
+			cfg.Port = 5432
+		}
+	}
+	return cfg
+}
+
+// dbKey 优先使用<prefix>.<key>，否则使用全局<section>.<key>
+func dbKey(prefix, section, key string) string {
+	if viper.IsSet(prefix + "." + key) {
+		return prefix + "." + key
+	}
+	return section + "." + key
+}
+
+// ErrDBNotConfigured 命名连接未配置
+var ErrDBNotConfigured = errors.New("数据库未配置")
+
+// DBRegistry 启动时按配置创建的共享连接池，各任务按名称获取
+type DBRegistry struct {
+	mu      sync.RWMutex
+	dbs     map[string]*sql.DB
+	configs map[string]DBConfig
+}
+
+// DBs 全局数据库连接池注册表
+var DBs = &DBRegistry{dbs: make(map[string]*sql.DB), configs: make(map[string]DBConfig)}
+
+// InitDB 创建已配置的命名连接池并注册连接池指标，连接失败只记录日志不阻止启动
+func InitDB() error {
+	for _, def := range dbDefinitions {
+		cfg := GetDBConfig(def.prefix)
+		if cfg.Host == "" {
+			slog.Info("数据库未配置，跳过", "db", def.name)
+			continue
+		}
+		if err := DBs.open(def.name, cfg); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	DBs.pingAll(ctx)
+
+	if interval := viper.GetDuration("DB_POOL.PING_INTERVAL"); interval > 0 {
+		go DBs.pingLoop(interval)
+	}
+	return nil
+}
+
+func (r *DBRegistry) open(name string, cfg DBConfig) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return fmt.Errorf("打开数据库%s失败: %w", name, err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	// go_sql_* 连接池指标，db_name标签为连接名
+	if err := prometheus.Register(collectors.NewDBStatsCollector(db, name)); err != nil {
+		db.Close()
+		return fmt.Errorf("注册数据库%s连接池指标失败: %w", name, err)
+	}
+
+	r.mu.Lock()
+	r.dbs[name] = db
+	r.configs[name] = cfg
+	r.mu.Unlock()
+	slog.Info("数据库连接池已创建", "db", name, "host", cfg.Host, "database", cfg.Database,
+		"max_open_conns", cfg.MaxOpenConns)
+	return nil
+}
+
+// Get 按名称获取连接池
+func (r *DBRegistry) Get(name string) (*sql.DB, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	db, ok := r.dbs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDBNotConfigured, name)
+	}
+	return db, nil
+}
+
+// Config 按名称获取连接配置
+func (r *DBRegistry) Config(name string) (DBConfig, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cfg, ok := r.configs[name]
+	if !ok {
+		return DBConfig{}, fmt.Errorf("%w: %s", ErrDBNotConfigured, name)
+	}
+	return cfg, nil
+}
+
+// Ping 检查连接是否可用，并更新webhook_db_up指标
+func (r *DBRegistry) Ping(ctx context.Context, name string) error {
+	db, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		dbUp.WithLabelValues(name).Set(0)
+	} else {
+		dbUp.WithLabelValues(name).Set(1)
+	}
+	return err
+}
+
+// Names 已创建的连接名
+func (r *DBRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for _, def := range dbDefinitions {
+		if _, ok := r.dbs[def.name]; ok {
+			names = append(names, def.name)
+		}
+	}
+	return names
+}
+
+func (r *DBRegistry) pingAll(ctx context.Context) {
+	for _, name := range r.Names() {
+		if err := r.Ping(ctx, name); err != nil {
+			slog.Warn("数据库连接检查失败", "db", name, "error", err)
+		}
+	}
+}
+
+// pingLoop 定期探活，连接池中的失效连接会被替换
+func (r *DBRegistry) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		r.pingAll(ctx)
+		cancel()
+	}
+}
+
+// withDB 将依赖命名连接的任务包装为JobFunc
+func withDB(name string, run func(ctx context.Context, db *sql.DB) error) JobFunc {
+	return func(ctx context.Context) error {
+		db, err := DBs.Get(name)
+		if err != nil {
+			return err
+		}
+		return run(ctx, db)
+	}
+}
@@ -0,0 +1,451 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Dify应用名，对应固定配置的API key，其他应用在DIFY.APPS中按名称配置
+const (
+	DifyAppChat          = "chat"           // 访视阶段判断对话应用，DIFY_CHAT_API_KEY
+	DifyAppVisitWorkflow = "visit_workflow" // 访视与孕周判断工作流，DIFY_WORKFLOW_API_KEY
+	DifyAppMZWorkflow    = "mz_workflow"    // 门诊指标提取工作流，DIFY_WORKFLOW_API_KEY_MZ
+)
+
+// ErrDifyCircuitOpen 应用连续失败后熔断，DIFY.BREAKER_COOLDOWN内不再调用
+var ErrDifyCircuitOpen = errors.New("Dify调用已熔断")
+
+// DifyError Dify接口返回的非2xx响应，Code、Message取自响应体
+type DifyError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RetryAfter time.Duration // 429响应的Retry-After
+}
+
+func (e *DifyError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("Dify HTTP %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("Dify HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// DifyUsage 模型调用的token用量，位于对话响应的metadata.usage，Latency单位为秒
+type DifyUsage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	TotalPrice       string  `json:"total_price"`
+	Currency         string  `json:"currency"`
+	Latency          float64 `json:"latency"`
+}
+
+// DifyChatResponse 对话应用blocking模式响应
+type DifyChatResponse struct {
+	MessageID      string `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+	Answer         string `json:"answer"`
+	Metadata       struct {
+		Usage DifyUsage `json:"usage"`
+	} `json:"metadata"`
+}
+
+// DifyWorkflowResponse 工作流blocking模式响应
+type DifyWorkflowResponse struct {
+	WorkflowRunID string `json:"workflow_run_id"`
+	TaskID        string `json:"task_id"`
+	Data          struct {
+		ID          string         `json:"id"`
+		WorkflowID  string         `json:"workflow_id"`
+		Status      string         `json:"status"`
+		Outputs     map[string]any `json:"outputs"`
+		Error       string         `json:"error"`
+		ElapsedTime float64        `json:"elapsed_time"`
+		TotalTokens int64          `json:"total_tokens"`
+		TotalSteps  int            `json:"total_steps"`
+	} `json:"data"`
+}
+
+// DifyGateway 访问Dify服务的所有应用，按应用名选择API key，各应用独立熔断。并发安全
+type DifyGateway struct {
+	baseURL    string
+	user       string
+	client     *http.Client
+	timeout    time.Duration
+	maxRetries int
+	apps       map[string]string
+	breakers   map[string]*difyBreaker
+
+	streamingApps map[string]bool
+}
+
+var (
+	difyMu      sync.Mutex
+	difyGateway *DifyGateway
+)
+
+// InitDify 启动时按配置创建全局Dify网关，失败不影响服务启动，调用Dify时重新创建
+func InitDify() error {
+	_, err := Dify()
+	return err
+}
+
+// Dify 返回全局Dify网关；尚未创建或上次创建失败(如配置未加载)时按当前配置创建，失败不缓存
+func Dify() (*DifyGateway, error) {
+	difyMu.Lock()
+	defer difyMu.Unlock()
+	if difyGateway != nil {
+		return difyGateway, nil
+	}
+	g, err := NewDifyGateway()
+	if err != nil {
+		return nil, err
+	}
+	difyGateway = g
+	return g, nil
+}
+
+// NewDifyGateway 按配置创建Dify网关：DIFY_API_BASE_URL为服务地址，DIFY_API_TIMEOUT为单次调用超时(秒)，
+// DIFY_API_MAX_RETRY为每次调用的最多尝试次数；固定应用与DIFY.APPS中的应用共用一个连接池
+func NewDifyGateway() (*DifyGateway, error) {
+	baseURL := strings.TrimSuffix(viper.GetString("DIFY_API_BASE_URL"), "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("未配置DIFY_API_BASE_URL")
+	}
+	apps := map[string]string{
+		DifyAppChat:          viper.GetString("DIFY_CHAT_API_KEY"),
+		DifyAppVisitWorkflow: viper.GetString("DIFY_WORKFLOW_API_KEY"),
+		DifyAppMZWorkflow:    viper.GetString("DIFY_WORKFLOW_API_KEY_MZ"),
+	}
+	for name, key := range viper.GetStringMapString("DIFY.APPS") {
+		apps[name] = key
+	}
+	g := &DifyGateway{
+		baseURL:    baseURL,
+		user:       viper.GetString("DIFY_API_USER"),
+		client:     &http.Client{},
+		timeout:    time.Duration(viper.GetInt("DIFY_API_TIMEOUT")) * time.Second,
+		maxRetries: viper.GetInt("DIFY_API_MAX_RETRY"),
+		apps:       make(map[string]string, len(apps)),
+		breakers:   make(map[string]*difyBreaker, len(apps)),
+	}
+	if g.maxRetries <= 0 {
+		g.maxRetries = 3
+	}
+	g.streamingApps = make(map[string]bool)
+	for _, name := range viper.GetStringSlice("DIFY.STREAMING_APPS") {
+		g.streamingApps[name] = true
+	}
+	for name, key := range apps {
+		if key == "" {
+			continue
+		}
+		g.apps[name] = key
+		g.breakers[name] = &difyBreaker{
+			app:       name,
+			threshold: viper.GetInt("DIFY.BREAKER_FAILURES"),
+			cooldown:  viper.GetDuration("DIFY.BREAKER_COOLDOWN"),
+		}
+	}
+	slog.Info("Dify网关初始化成功", "base_url", baseURL, "apps", len(g.apps))
+	return g, nil
+}
+
+// ChatMessage 向对话应用发送消息，应用在DIFY.STREAMING_APPS中时使用streaming模式，拼接的结果与blocking模式相同
+func (g *DifyGateway) ChatMessage(ctx context.Context, app, query string, inputs map[string]any) (*DifyChatResponse, error) {
+	if inputs == nil {
+		inputs = map[string]any{}
+	}
+	var resp DifyChatResponse
+	mode, decode := "blocking", decodeJSON(&resp)
+	if g.streaming(app) {
+		mode, decode = "streaming", func(r io.Reader) error {
+			resp = DifyChatResponse{}
+			return decodeChatStream(ctx, app, r, &resp)
+		}
+	}
+	err := g.call(ctx, app, "/v1/chat-messages", map[string]any{
+		"inputs":        inputs,
+		"query":         query,
+		"response_mode": mode,
+		"user":          g.user,
+	}, decode)
+	if err != nil {
+		return nil, err
+	}
+	recordDifyUsage(ctx, app, resp.MessageID, resp.Metadata.Usage)
+	return &resp, nil
+}
+
+// RunWorkflow 运行工作流应用，应用在DIFY.STREAMING_APPS中时使用streaming模式，工作流本身执行失败时由调用方检查Data.Status
+func (g *DifyGateway) RunWorkflow(ctx context.Context, app string, inputs map[string]any) (*DifyWorkflowResponse, error) {
+	var resp DifyWorkflowResponse
+	mode, decode := "blocking", decodeJSON(&resp)
+	if g.streaming(app) {
+		mode, decode = "streaming", func(r io.Reader) error {
+			resp = DifyWorkflowResponse{}
+			return decodeWorkflowStream(ctx, app, r, &resp)
+		}
+	}
+	err := g.call(ctx, app, "/v1/workflows/run", map[string]any{
+		"inputs":        inputs,
+		"response_mode": mode,
+		"user":          g.user,
+	}, decode)
+	if err != nil {
+		return nil, err
+	}
+	// 工作流响应只有总token数
+	recordDifyUsage(ctx, app, resp.WorkflowRunID, DifyUsage{TotalTokens: resp.Data.TotalTokens, Latency: resp.Data.ElapsedTime})
+	return &resp, nil
+}
+
+// streaming 应用是否使用streaming模式
+func (g *DifyGateway) streaming(app string) bool {
+	return g.streamingApps[app]
+}
+
+// decodeJSON blocking模式的响应解析
+func decodeJSON(out any) func(io.Reader) error {
+	return func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("解析Dify响应失败: %w", err)
+		}
+		return nil
+	}
+}
+
+// call 调用应用接口并解析响应，可重试的错误按指数退避重试，熔断时直接返回ErrDifyCircuitOpen
+func (g *DifyGateway) call(ctx context.Context, app, path string, body any, decode func(io.Reader) error) error {
+	key, ok := g.apps[app]
+	if !ok {
+		return fmt.Errorf("未配置Dify应用%s的API key", app)
+	}
+	breaker := g.breakers[app]
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < g.maxRetries; attempt++ {
+		if !breaker.allow() {
+			difyFailuresTotal.WithLabelValues(app, "circuit_open").Inc()
+			if lastErr != nil {
+				return fmt.Errorf("%w: %w", ErrDifyCircuitOpen, lastErr)
+			}
+			return ErrDifyCircuitOpen
+		}
+
+		start := time.Now()
+		callCtx, span := startDifySpan(ctx, app, attempt)
+		err := g.do(callCtx, key, path, payload, g.streaming(app), decode)
+		endSpan(span, err)
+		observeDify(app, attempt, start, err)
+		if err != nil && ctx.Err() != nil {
+			// 调用方取消，不计入熔断
+			breaker.abort()
+			return ctx.Err()
+		}
+		retryable := err != nil && difyRetryable(err)
+		breaker.record(err == nil || !retryable)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retryable {
+			difyFailuresTotal.WithLabelValues(app, "request").Inc()
+			return err
+		}
+
+		slog.WarnContext(ctx, "调用Dify API失败", "app", app, "attempt", attempt+1, "max_retries", g.maxRetries, "error", err)
+		if attempt < g.maxRetries-1 {
+			if err := sleepContext(ctx, difyBackoff(attempt, err)); err != nil {
+				return err
+			}
+		}
+	}
+	difyFailuresTotal.WithLabelValues(app, "request").Inc()
+	return lastErr
+}
+
+// do 发送一次请求并由decode解析响应，超时由DIFY_API_TIMEOUT限制。streaming模式下长时间运行的工作流
+// 持续输出事件，DIFY_API_TIMEOUT只限制等待响应头，之后由DIFY.STREAM_IDLE_TIMEOUT限制事件间隔
+func (g *DifyGateway) do(ctx context.Context, key, path string, payload []byte, streaming bool, decode func(io.Reader) error) error {
+	var headerTimer *time.Timer
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		if streaming {
+			ctx, cancel = context.WithCancel(ctx)
+			headerTimer = time.AfterFunc(g.timeout, cancel)
+		} else {
+			ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		}
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if headerTimer != nil && !headerTimer.Stop() {
+		// 等待响应头超时，按单次调用超时重试
+		if err == nil {
+			resp.Body.Close()
+		}
+		return fmt.Errorf("等待Dify响应超过%s: %w", g.timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return newDifyError(resp, data)
+	}
+	return decode(resp.Body)
+}
+
+// newDifyError 解析Dify的错误响应体{"code": "...", "message": "...", "status": 400}
+func newDifyError(resp *http.Response, data []byte) *DifyError {
+	e := &DifyError{StatusCode: resp.StatusCode}
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &body) == nil && (body.Code != "" || body.Message != "") {
+		e.Code, e.Message = body.Code, body.Message
+	} else {
+		e.Message = strings.TrimSpace(string(data))
+		if len(e.Message) > 200 {
+			e.Message = e.Message[:200]
+		}
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
+
+// difyRetryable 5xx、429、单次调用超时与网络错误可重试；其他4xx与响应解析失败重试也不会成功
+func difyRetryable(err error) bool {
+	var de *DifyError
+	if errors.As(err, &de) {
+		return de.StatusCode == http.StatusTooManyRequests || de.StatusCode >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// difyBackoff 第attempt次失败后的等待时间：DIFY.RETRY_BASE按2的指数增长，不超过DIFY.RETRY_MAX，
+// 取一半到全部之间的随机值，避免多个worker同时重试；429响应按Retry-After等待
+func difyBackoff(attempt int, err error) time.Duration {
+	base := viper.GetDuration("DIFY.RETRY_BASE")
+	max := viper.GetDuration("DIFY.RETRY_MAX")
+	d := base << attempt
+	if d <= 0 || d > max {
+		d = max
+	}
+	if d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	var de *DifyError
+	if errors.As(err, &de) && de.RetryAfter > d {
+		d = de.RetryAfter
+	}
+	return d
+}
+
+// difyBreaker 单个应用的熔断器：连续threshold次可重试的失败后打开，cooldown后放行一次探测调用，
+// 探测成功则关闭，失败则重新打开
+type difyBreaker struct {
+	app       string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow 返回是否可以发起调用，threshold不大于0时不熔断
+func (b *difyBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// abort 调用被取消，结果不计入熔断
+func (b *difyBreaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// record 记录调用结果，ok为false表示服务端故障
+func (b *difyBreaker) record(ok bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.threshold
+	b.probing = false
+	if ok {
+		b.failures = 0
+		if wasOpen {
+			slog.Info("Dify熔断恢复", "app", b.app)
+			difyCircuitOpen.WithLabelValues(b.app).Set(0)
+		}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if !wasOpen {
+			slog.Warn("Dify连续调用失败，熔断", "app", b.app, "failures", b.failures, "cooldown", b.cooldown)
+			difyCircuitOpen.WithLabelValues(b.app).Set(1)
+		}
+	}
+}
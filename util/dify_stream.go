@@ -0,0 +1,206 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// errDifyStreamIdle streaming响应超过DIFY.STREAM_IDLE_TIMEOUT没有任何事件，Dify正常每10秒发送一次ping
+var errDifyStreamIdle = errors.New("Dify streaming响应超时无事件")
+
+// difyStreamEvent streaming模式的SSE事件，data:后的JSON
+type difyStreamEvent struct {
+	Event          string          `json:"event"`
+	TaskID         string          `json:"task_id"`
+	WorkflowRunID  string          `json:"workflow_run_id"`
+	MessageID      string          `json:"message_id"`
+	ConversationID string          `json:"conversation_id"`
+	Answer         string          `json:"answer"`
+	Data           json.RawMessage `json:"data"`
+	Metadata       struct {
+		Usage DifyUsage `json:"usage"`
+	} `json:"metadata"`
+	// error事件
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// difyNodeFinished node_finished事件的data
+type difyNodeFinished struct {
+	NodeID            string  `json:"node_id"`
+	NodeType          string  `json:"node_type"`
+	Title             string  `json:"title"`
+	Status            string  `json:"status"`
+	Error             string  `json:"error"`
+	ElapsedTime       float64 `json:"elapsed_time"`
+	ExecutionMetadata *struct {
+		TotalTokens int64 `json:"total_tokens"`
+	} `json:"execution_metadata"`
+}
+
+// readDifyStream 逐个读取SSE事件交给handle，handle返回io.EOF时结束读取。
+// 两次事件间隔超过DIFY.STREAM_IDLE_TIMEOUT时按超时返回，可重试
+func readDifyStream(ctx context.Context, r io.Reader, handle func(difyStreamEvent) error) error {
+	// 超过空闲时间关闭响应体使读取返回，每读到一行重新计时
+	var expired atomic.Bool
+	idle := viper.GetDuration("DIFY.STREAM_IDLE_TIMEOUT")
+	var timer *time.Timer
+	if rc, ok := r.(io.Closer); ok && idle > 0 {
+		timer = time.AfterFunc(idle, func() {
+			expired.Store(true)
+			rc.Close()
+		})
+		defer timer.Stop()
+	}
+
+	br := bufio.NewReader(r)
+	var data bytes.Buffer
+	for {
+		line, err := br.ReadBytes('\n')
+		if timer != nil {
+			timer.Reset(idle)
+		}
+		if len(line) > 0 {
+			line = bytes.TrimRight(line, "\r\n")
+			switch {
+			case len(line) == 0:
+				// 空行结束一个事件
+				if data.Len() > 0 {
+					var ev difyStreamEvent
+					if err := json.Unmarshal(data.Bytes(), &ev); err != nil {
+						return fmt.Errorf("解析Dify事件失败: %w", err)
+					}
+					data.Reset()
+					if err := handle(ev); err != nil {
+						if errors.Is(err, io.EOF) {
+							return nil
+						}
+						return err
+					}
+				}
+			case bytes.HasPrefix(line, []byte("data:")):
+				data.Write(bytes.TrimSpace(line[len("data:"):]))
+			}
+		}
+		if err != nil {
+			if expired.Load() {
+				return fmt.Errorf("%w: %w", errDifyStreamIdle, context.DeadlineExceeded)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				// 没有收到结束事件就断开
+				return fmt.Errorf("Dify streaming响应提前结束: %w", io.ErrUnexpectedEOF)
+			}
+			return err
+		}
+	}
+}
+
+// streamError error事件转为DifyError，按status判断是否重试
+func (ev difyStreamEvent) streamError() error {
+	status := ev.Status
+	if status == 0 {
+		status = 500
+	}
+	return &DifyError{StatusCode: status, Code: ev.Code, Message: ev.Message}
+}
+
+// decodeWorkflowStream 解析工作流streaming响应，workflow_finished的data即blocking模式响应的data
+func decodeWorkflowStream(ctx context.Context, app string, r io.Reader, out *DifyWorkflowResponse) error {
+	finished := false
+	err := readDifyStream(ctx, r, func(ev difyStreamEvent) error {
+		switch ev.Event {
+		case "workflow_started":
+			out.WorkflowRunID, out.TaskID = ev.WorkflowRunID, ev.TaskID
+			slog.DebugContext(ctx, "Dify工作流开始", "app", app, "workflow_run_id", ev.WorkflowRunID)
+		case "node_finished":
+			logDifyNode(ctx, app, ev.Data)
+		case "workflow_finished":
+			if out.WorkflowRunID == "" {
+				out.WorkflowRunID, out.TaskID = ev.WorkflowRunID, ev.TaskID
+			}
+			if err := json.Unmarshal(ev.Data, &out.Data); err != nil {
+				return fmt.Errorf("解析workflow_finished事件失败: %w", err)
+			}
+			finished = true
+			slog.InfoContext(ctx, "Dify工作流完成", "app", app, "workflow_run_id", out.WorkflowRunID,
+				"status", out.Data.Status, "elapsed", out.Data.ElapsedTime, "steps", out.Data.TotalSteps,
+				"total_tokens", out.Data.TotalTokens)
+			return io.EOF
+		case "error":
+			return ev.streamError()
+		}
+		return nil
+	})
+	if err == nil && !finished {
+		err = fmt.Errorf("Dify streaming响应缺少workflow_finished: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// decodeChatStream 解析对话streaming响应，按顺序拼接message/agent_message的answer，message_end携带用量
+func decodeChatStream(ctx context.Context, app string, r io.Reader, out *DifyChatResponse) error {
+	var answer strings.Builder
+	ended := false
+	err := readDifyStream(ctx, r, func(ev difyStreamEvent) error {
+		switch ev.Event {
+		case "message", "agent_message":
+			answer.WriteString(ev.Answer)
+		case "message_replace":
+			// 内容审查替换整条回答
+			answer.Reset()
+			answer.WriteString(ev.Answer)
+		case "node_finished":
+			logDifyNode(ctx, app, ev.Data)
+		case "message_end":
+			out.MessageID, out.ConversationID = ev.MessageID, ev.ConversationID
+			out.Metadata.Usage = ev.Metadata.Usage
+			ended = true
+			slog.DebugContext(ctx, "Dify对话完成", "app", app, "message_id", ev.MessageID,
+				"total_tokens", ev.Metadata.Usage.TotalTokens, "latency", ev.Metadata.Usage.Latency)
+			return io.EOF
+		case "error":
+			return ev.streamError()
+		}
+		return nil
+	})
+	if err == nil && !ended {
+		err = fmt.Errorf("Dify streaming响应缺少message_end: %w", io.ErrUnexpectedEOF)
+	}
+	out.Answer = answer.String()
+	return err
+}
+
+// logDifyNode 记录节点耗时与token用量，失败的节点输出警告
+func logDifyNode(ctx context.Context, app string, data json.RawMessage) {
+	var node difyNodeFinished
+	if err := json.Unmarshal(data, &node); err != nil {
+		return
+	}
+	var tokens int64
+	if node.ExecutionMetadata != nil {
+		tokens = node.ExecutionMetadata.TotalTokens
+	}
+	difyNodeDuration.WithLabelValues(app, node.NodeType, node.Status).Observe(node.ElapsedTime)
+	if node.Status != "succeeded" {
+		slog.WarnContext(ctx, "Dify节点执行失败", "app", app, "node", node.Title, "node_type", node.NodeType,
+			"status", node.Status, "error", node.Error)
+		return
+	}
+	slog.DebugContext(ctx, "Dify节点完成", "app", app, "node", node.Title, "node_type", node.NodeType,
+		"elapsed", node.ElapsedTime, "total_tokens", tokens)
+}
@@ -0,0 +1,270 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// fakeDify 本地模拟的Dify服务，blocking请求返回JSON，streaming请求按events逐个输出SSE事件
+type fakeDify struct {
+	calls atomic.Int32
+	// blocking blocking模式的响应体
+	blocking any
+	// stream 第n次(从1开始)streaming请求的处理
+	stream func(n int, w *sseWriter)
+}
+
+type sseWriter struct {
+	w http.ResponseWriter
+}
+
+func (s *sseWriter) event(v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(s.w, "data: %s\n\n", data)
+	s.w.(http.Flusher).Flush()
+}
+
+func (s *sseWriter) ping() {
+	fmt.Fprint(s.w, "event: ping\n\n")
+	s.w.(http.Flusher).Flush()
+}
+
+func (f *fakeDify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(f.calls.Add(1))
+	var body struct {
+		ResponseMode string `json:"response_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.ResponseMode == "blocking" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f.blocking)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	f.stream(n, &sseWriter{w: w})
+}
+
+// newTestDifyGateway 创建指向fake的网关，streaming为true时两个应用都使用streaming模式
+func newTestDifyGateway(t *testing.T, fake *fakeDify, streaming bool) *DifyGateway {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	t.Cleanup(viper.Reset)
+
+	viper.Set("DIFY_API_BASE_URL", srv.URL)
+	viper.Set("DIFY_CHAT_API_KEY", "app-chat")
+	viper.Set("DIFY_WORKFLOW_API_KEY_MZ", "app-mz")
+	viper.Set("DIFY_API_TIMEOUT", 1)
+	viper.Set("DIFY_API_MAX_RETRY", 3)
+	viper.Set("DIFY.RETRY_BASE", "1ms")
+	viper.Set("DIFY.RETRY_MAX", "5ms")
+	viper.Set("DIFY.BREAKER_FAILURES", 0)
+	viper.Set("DIFY.STREAM_IDLE_TIMEOUT", "300ms")
+	if streaming {
+		viper.Set("DIFY.STREAMING_APPS", []string{DifyAppChat, DifyAppMZWorkflow})
+	}
+	g, err := NewDifyGateway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+var testWorkflowData = map[string]any{
+	"id":           "run-1",
+	"workflow_id":  "wf-1",
+	"status":       "succeeded",
+	"outputs":      map[string]any{"result": `[{"code":"1","value":"3"}]`},
+	"elapsed_time": 1.5,
+	"total_tokens": 321,
+	"total_steps":  4,
+}
+
+// workflowStream 正常的工作流事件序列，before在workflow_finished之前执行
+func workflowStream(w *sseWriter, before func()) {
+	w.event(map[string]any{"event": "workflow_started", "task_id": "task-1", "workflow_run_id": "run-1"})
+	w.ping()
+	w.event(map[string]any{"event": "node_finished", "data": map[string]any{
+		"node_id": "llm", "node_type": "llm", "title": "LLM", "status": "succeeded", "elapsed_time": 1.2,
+		"execution_metadata": map[string]any{"total_tokens": 321},
+	}})
+	if before != nil {
+		before()
+	}
+	w.event(map[string]any{"event": "workflow_finished", "task_id": "task-1", "workflow_run_id": "run-1", "data": testWorkflowData})
+}
+
+func TestDifyWorkflowStreamMatchesBlocking(t *testing.T) {
+	fake := &fakeDify{
+		blocking: map[string]any{"workflow_run_id": "run-1", "task_id": "task-1", "data": testWorkflowData},
+		stream:   func(_ int, w *sseWriter) { workflowStream(w, nil) },
+	}
+	blocking, err := newTestDifyGateway(t, fake, false).RunWorkflow(context.Background(), DifyAppMZWorkflow, nil)
+	if err != nil {
+		t.Fatalf("blocking: %v", err)
+	}
+	streamed, err := newTestDifyGateway(t, fake, true).RunWorkflow(context.Background(), DifyAppMZWorkflow, nil)
+	if err != nil {
+		t.Fatalf("streaming: %v", err)
+	}
+	if !reflect.DeepEqual(blocking, streamed) {
+		t.Errorf("streaming响应与blocking不同:\nblocking:  %+v\nstreaming: %+v", blocking, streamed)
+	}
+	if streamed.Data.TotalTokens != 321 || streamed.Data.Outputs["result"] == nil {
+		t.Errorf("streaming响应缺少输出或用量: %+v", streamed.Data)
+	}
+}
+
+func TestDifyChatStreamMatchesBlocking(t *testing.T) {
+	usage := map[string]any{"prompt_tokens": 100, "completion_tokens": 20, "total_tokens": 120,
+		"total_price": "0.0012", "currency": "USD", "latency": 0.8}
+	fake := &fakeDify{
+		blocking: map[string]any{"message_id": "msg-1", "conversation_id": "conv-1", "answer": "孕周为12周",
+			"metadata": map[string]any{"usage": usage}},
+		stream: func(_ int, w *sseWriter) {
+			for _, chunk := range []string{"孕周", "为", "11周"} {
+				w.event(map[string]any{"event": "message", "answer": chunk})
+			}
+			// 内容审查替换整条回答，之后的片段继续追加
+			w.event(map[string]any{"event": "message_replace", "answer": "孕周为"})
+			w.event(map[string]any{"event": "agent_message", "answer": "12周"})
+			w.event(map[string]any{"event": "message_end", "message_id": "msg-1", "conversation_id": "conv-1",
+				"metadata": map[string]any{"usage": usage}})
+		},
+	}
+	blocking, err := newTestDifyGateway(t, fake, false).ChatMessage(context.Background(), DifyAppChat, "q", nil)
+	if err != nil {
+		t.Fatalf("blocking: %v", err)
+	}
+	streamed, err := newTestDifyGateway(t, fake, true).ChatMessage(context.Background(), DifyAppChat, "q", nil)
+	if err != nil {
+		t.Fatalf("streaming: %v", err)
+	}
+	if !reflect.DeepEqual(blocking, streamed) {
+		t.Errorf("streaming响应与blocking不同:\nblocking:  %+v\nstreaming: %+v", blocking, streamed)
+	}
+}
+
+func TestDifyStreamErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// stream 第n次请求的事件，返回前不输出结束事件即为提前断开
+		stream    func(n int, w *sseWriter)
+		wantErr   bool
+		wantCalls int32
+		check     func(t *testing.T, err error)
+	}{
+		{
+			name: "可重试的error事件后成功",
+			stream: func(n int, w *sseWriter) {
+				if n == 1 {
+					w.event(map[string]any{"event": "error", "status": 500, "code": "internal_error", "message": "model crashed"})
+					return
+				}
+				workflowStream(w, nil)
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "不可重试的error事件",
+			stream: func(_ int, w *sseWriter) {
+				w.event(map[string]any{"event": "workflow_started", "workflow_run_id": "run-1"})
+				w.event(map[string]any{"event": "error", "status": 400, "code": "invalid_param", "message": "bad input"})
+			},
+			wantErr:   true,
+			wantCalls: 1,
+			check: func(t *testing.T, err error) {
+				var de *DifyError
+				if !errors.As(err, &de) || de.StatusCode != 400 || de.Code != "invalid_param" {
+					t.Errorf("应为400的DifyError: %v", err)
+				}
+			},
+		},
+		{
+			name: "没有workflow_finished就断开",
+			stream: func(_ int, w *sseWriter) {
+				w.event(map[string]any{"event": "workflow_started", "workflow_run_id": "run-1"})
+			},
+			wantErr:   true,
+			wantCalls: 3,
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("应为ErrUnexpectedEOF: %v", err)
+				}
+			},
+		},
+		{
+			name: "事件间隔超过空闲超时",
+			stream: func(n int, w *sseWriter) {
+				workflowStream(w, func() {
+					if n == 1 {
+						time.Sleep(time.Second)
+					}
+				})
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "总时长超过DIFY_API_TIMEOUT但持续有事件",
+			stream: func(_ int, w *sseWriter) {
+				workflowStream(w, func() {
+					for i := 0; i < 8; i++ {
+						time.Sleep(200 * time.Millisecond)
+						w.ping()
+					}
+				})
+			},
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDify{stream: tt.stream}
+			g := newTestDifyGateway(t, fake, true)
+			resp, err := g.RunWorkflow(context.Background(), DifyAppMZWorkflow, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := fake.calls.Load(); got != tt.wantCalls {
+				t.Errorf("调用次数 = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.check != nil {
+				tt.check(t, err)
+			}
+			if err == nil && (resp.Data.Status != "succeeded" || resp.WorkflowRunID != "run-1") {
+				t.Errorf("响应不完整: %+v", resp)
+			}
+		})
+	}
+}
+
+func TestDifyStreamIdleTimeoutIsRetryable(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	t.Cleanup(viper.Reset)
+	viper.Set("DIFY.STREAM_IDLE_TIMEOUT", "50ms")
+	go fmt.Fprint(pw, "event: ping\n\n")
+
+	var out DifyWorkflowResponse
+	err := decodeWorkflowStream(context.Background(), DifyAppMZWorkflow, pr, &out)
+	if !errors.Is(err, errDifyStreamIdle) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("应为空闲超时: %v", err)
+	}
+	if !difyRetryable(err) {
+		t.Errorf("空闲超时应可重试: %v", err)
+	}
+}
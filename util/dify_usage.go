@@ -0,0 +1,247 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Dify调用用量，写入pg_struct库，按任务、就诊与阶段统计token消耗
+const createDifyUsageSQL = `
+CREATE TABLE IF NOT EXISTS public.dify_usage (
+	id                bigserial PRIMARY KEY,
+	job_id            varchar(64),
+	job_type          varchar(64),
+	app               varchar(64) NOT NULL,
+	encounter_id      varchar(64),
+	stage             varchar(32),
+	run_id            varchar(64),
+	prompt_tokens     bigint NOT NULL DEFAULT 0,
+	completion_tokens bigint NOT NULL DEFAULT 0,
+	total_tokens      bigint NOT NULL DEFAULT 0,
+	total_price       numeric(18, 7) NOT NULL DEFAULT 0,
+	currency          varchar(8),
+	latency_seconds   double precision NOT NULL DEFAULT 0,
+	created_at        timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_dify_usage_created_at ON public.dify_usage (created_at);
+CREATE INDEX IF NOT EXISTS idx_dify_usage_job_id ON public.dify_usage (job_id);
+CREATE INDEX IF NOT EXISTS idx_dify_usage_encounter_id ON public.dify_usage (encounter_id);`
+
+// DifyUsageStore Dify用量存储
+type DifyUsageStore struct {
+	db *sql.DB
+}
+
+// DifyUsages 全局Dify用量存储，未启用时为nil，只记录指标与任务计数
+var DifyUsages *DifyUsageStore
+
+// InitDifyUsage 连接pg_struct并创建dify_usage表
+func InitDifyUsage() error {
+	if !viper.GetBool("DIFY.USAGE_ENABLED") {
+		slog.Info("Dify用量记录未启用")
+		return nil
+	}
+	db, err := DBs.Get(DBPgStruct)
+	if err != nil {
+		return fmt.Errorf("Dify用量数据库不可用: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, createDifyUsageSQL); err != nil {
+		return fmt.Errorf("创建dify_usage表失败: %w", err)
+	}
+	DifyUsages = &DifyUsageStore{db: db}
+	slog.Info("Dify用量记录已启用")
+	return nil
+}
+
+type difyUsageKey struct{}
+
+// difyUsageLabels 调用方附加到ctx的用量维度
+type difyUsageLabels struct {
+	EncounterID string
+	Stage       string
+}
+
+// WithDifyUsage 为ctx中的Dify调用标记就诊ID与阶段(如门诊指标的fsjd)，用于按就诊、阶段统计用量
+func WithDifyUsage(ctx context.Context, encounterID, stage string) context.Context {
+	return context.WithValue(ctx, difyUsageKey{}, difyUsageLabels{EncounterID: encounterID, Stage: stage})
+}
+
+// recordDifyUsage 记录一次成功调用的用量：Prometheus计数、任务计数dify_calls/dify_tokens，启用时写入dify_usage表
+func recordDifyUsage(ctx context.Context, app, runID string, usage DifyUsage) {
+	job := JobFromContext(ctx)
+	jobType := ""
+	if job != nil {
+		jobType = job.Type
+	}
+	difyTokensTotal.WithLabelValues(app, jobType, "prompt").Add(float64(usage.PromptTokens))
+	difyTokensTotal.WithLabelValues(app, jobType, "completion").Add(float64(usage.CompletionTokens))
+	difyTokensTotal.WithLabelValues(app, jobType, "total").Add(float64(usage.TotalTokens))
+	price, _ := strconv.ParseFloat(usage.TotalPrice, 64)
+	if price > 0 {
+		difyCostTotal.WithLabelValues(app, usage.Currency).Add(price)
+	}
+	job.Add("dify_calls", 1)
+	job.Add("dify_tokens", usage.TotalTokens)
+
+	if DifyUsages == nil {
+		return
+	}
+	labels, _ := ctx.Value(difyUsageKey{}).(difyUsageLabels)
+	var jobID string
+	if job != nil {
+		jobID = job.ID
+	}
+	// 调用方取消后仍记录已消耗的用量
+	wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_, err := DifyUsages.db.ExecContext(wctx, `
+		INSERT INTO public.dify_usage (job_id, job_type, app, encounter_id, stage, run_id,
+			prompt_tokens, completion_tokens, total_tokens, total_price, currency, latency_seconds)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''),
+			$7, $8, $9, $10, NULLIF($11, ''), $12)`,
+		jobID, jobType, app, labels.EncounterID, labels.Stage, runID,
+		usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, price, usage.Currency, usage.Latency)
+	if err != nil {
+		slog.WarnContext(ctx, "写入Dify用量失败", "app", app, "error", err)
+	}
+}
+
+// DifyUsageQuery 用量统计条件，GroupBy为空时汇总为一行
+type DifyUsageQuery struct {
+	From        time.Time
+	To          time.Time
+	JobType     string
+	JobID       string
+	App         string
+	EncounterID string
+	Stage       string
+	GroupBy     []string
+}
+
+// DifyUsageRow 一个分组的用量合计，Group的键为GroupBy中的维度
+type DifyUsageRow struct {
+	Group            map[string]string `json:"group,omitempty"`
+	Calls            int64             `json:"calls"`
+	PromptTokens     int64             `json:"prompt_tokens"`
+	CompletionTokens int64             `json:"completion_tokens"`
+	TotalTokens      int64             `json:"total_tokens"`
+	TotalPrice       float64           `json:"total_price"`
+	AvgLatency       float64           `json:"avg_latency_seconds"`
+}
+
+// difyUsageGroups 可用的分组维度
+var difyUsageGroups = map[string]string{
+	"job_type":     "COALESCE(job_type, '')",
+	"job_id":       "COALESCE(job_id, '')",
+	"app":          "app",
+	"encounter_id": "COALESCE(encounter_id, '')",
+	"stage":        "COALESCE(stage, '')",
+	"day":          "to_char(created_at AT TIME ZONE $tz, 'YYYY-MM-DD')", // $tz替换为DIFY.USAGE_TIMEZONE参数
+}
+
+// DifyUsageLocation 用量统计按天划分的时区(DIFY.USAGE_TIMEZONE)，查询的日期范围与day分组共用，
+// 不随数据库会话时区或服务器本地时区变化
+func DifyUsageLocation() (*time.Location, error) {
+	name := viper.GetString("DIFY.USAGE_TIMEZONE")
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("DIFY.USAGE_TIMEZONE需为时区名，如Asia/Shanghai")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("DIFY.USAGE_TIMEZONE无效: %w", err)
+	}
+	return loc, nil
+}
+
+// ValidDifyUsageGroup 分组维度是否可用
+func ValidDifyUsageGroup(name string) bool {
+	_, ok := difyUsageGroups[name]
+	return ok
+}
+
+// Summary 按条件与分组统计用量，按总token数倒序
+func (s *DifyUsageStore) Summary(ctx context.Context, q DifyUsageQuery) ([]DifyUsageRow, error) {
+	conds := []string{"created_at >= $1", "created_at < $2"}
+	args := []interface{}{q.From, q.To}
+	for _, f := range []struct{ col, val string }{
+		{"job_type", q.JobType}, {"job_id", q.JobID}, {"app", q.App}, {"encounter_id", q.EncounterID}, {"stage", q.Stage},
+	} {
+		if f.val == "" {
+			continue
+		}
+		args = append(args, f.val)
+		conds = append(conds, fmt.Sprintf("%s = $%d", f.col, len(args)))
+	}
+
+	exprs := make([]string, len(q.GroupBy))
+	for i, g := range q.GroupBy {
+		expr, ok := difyUsageGroups[g]
+		if !ok {
+			return nil, fmt.Errorf("不支持的分组: %s", g)
+		}
+		if strings.Contains(expr, "$tz") {
+			loc, err := DifyUsageLocation()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, loc.String())
+			expr = strings.ReplaceAll(expr, "$tz", "$"+strconv.Itoa(len(args)))
+		}
+		exprs[i] = expr
+	}
+	query := "SELECT "
+	for _, e := range exprs {
+		query += e + ", "
+	}
+	query += `count(*), sum(prompt_tokens), sum(completion_tokens), sum(total_tokens),
+		sum(total_price)::float8, COALESCE(avg(latency_seconds), 0)
+		FROM public.dify_usage WHERE ` + strings.Join(conds, " AND ")
+	if len(exprs) > 0 {
+		query += " GROUP BY " + strings.Join(exprs, ", ")
+	}
+	query += " ORDER BY sum(total_tokens) DESC NULLS LAST"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []DifyUsageRow
+	for rows.Next() {
+		var r DifyUsageRow
+		keys := make([]string, len(exprs))
+		var prompt, completion, total sql.NullInt64
+		var price sql.NullFloat64
+		dest := make([]interface{}, 0, len(exprs)+6)
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		dest = append(dest, &r.Calls, &prompt, &completion, &total, &price, &r.AvgLatency)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if r.Calls == 0 {
+			// 不分组且没有记录时聚合仍返回一行
+			continue
+		}
+		r.PromptTokens, r.CompletionTokens, r.TotalTokens, r.TotalPrice = prompt.Int64, completion.Int64, total.Int64, price.Float64
+		if len(keys) > 0 {
+			r.Group = make(map[string]string, len(keys))
+			for i, g := range q.GroupBy {
+				r.Group[g] = keys[i]
+			}
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
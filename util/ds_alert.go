@@ -0,0 +1,232 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// DolphinScheduler失败告警的检查点与去重表，写入pg_struct库，多实例切换主节点后不会重复告警
+const createDSAlertSQL = `
+CREATE TABLE IF NOT EXISTS public.ds_alert_checkpoint (
+	name       varchar(64) PRIMARY KEY,
+	last_time  timestamptz NOT NULL,
+	last_id    bigint NOT NULL DEFAULT 0,
+	updated_at timestamptz NOT NULL
+);
+ALTER TABLE public.ds_alert_checkpoint ADD COLUMN IF NOT EXISTS last_id bigint NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS public.ds_alert_sent (
+	process_instance_id bigint PRIMARY KEY,
+	sent_at             timestamptz NOT NULL
+);`
+
+// dsAlertCheckpoint 检查点名称
+const dsAlertCheckpoint = "process_instance"
+
+// DSFailure 一次失败的工作流实例
+type DSFailure struct {
+	InstanceID  int64
+	Instance    string
+	Workflow    string
+	Project     string
+	State       int
+	StartTime   time.Time
+	EndTime     time.Time
+	Host        string
+	FailedTasks []string
+}
+
+// dsStateNames 工作流实例状态名称
+var dsStateNames = map[int]string{5: "停止", 6: "失败", 9: "已终止"}
+
+// Message 短信内容
+func (f DSFailure) Message() string {
+	state := dsStateNames[f.State]
+	if state == "" {
+		state = strconv.Itoa(f.State)
+	}
+	msg := fmt.Sprintf("[DolphinScheduler]项目:%s 工作流:%s 实例:%d %s，结束时间:%s",
+		f.Project, f.Workflow, f.InstanceID, state, f.EndTime.Format("2006-01-02 15:04:05"))
+	if len(f.FailedTasks) > 0 {
+		msg += " 失败任务:" + strings.Join(f.FailedTasks, ",")
+	}
+	return msg
+}
+
+// DSAlert 查询上次检查点之后结束的失败工作流实例并发送短信，db为DolphinScheduler连接池。
+// 按实例ID去重，短信全部发送失败的实例在下次轮询时重试
+func DSAlert(ctx context.Context, db *sql.DB) error {
+	job := JobFromContext(ctx)
+	store, err := DBs.Get(DBPgStruct)
+	if err != nil {
+		return fmt.Errorf("告警检查点数据库不可用: %w", err)
+	}
+	cfg, err := DBs.Config(DBDolphinScheduler)
+	if err != nil {
+		return err
+	}
+	if _, err := store.ExecContext(ctx, createDSAlertSQL); err != nil {
+		return fmt.Errorf("创建告警检查点表失败: %w", err)
+	}
+
+	since, sinceID, err := dsAlertSince(ctx, store)
+	if err != nil {
+		return err
+	}
+	failures, err := queryDSFailures(ctx, db, cfg.Driver, since, sinceID)
+	if err != nil {
+		return err
+	}
+	job.SetTotal(int64(len(failures)))
+	slog.InfoContext(ctx, "查询DolphinScheduler失败实例", "since", since, "since_id", sinceID, "count", len(failures))
+
+	// 检查点推进到已处理的最后一个实例，但不越过发送失败的实例，以便下次重试
+	checkpoint, checkpointID, retry := since, sinceID, false
+	for _, f := range failures {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		job.Step(1)
+
+		// 先占位再发送，其他实例或下次轮询看到占位即跳过
+		res, err := store.ExecContext(ctx, `INSERT INTO public.ds_alert_sent (process_instance_id, sent_at)
+			VALUES ($1, now()) ON CONFLICT DO NOTHING`, f.InstanceID)
+		if err != nil {
+			return fmt.Errorf("记录告警失败: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			job.Add("duplicates", 1)
+			if !retry {
+				checkpoint, checkpointID = f.EndTime, f.InstanceID
+			}
+			continue
+		}
+
+		slog.InfoContext(ctx, "DolphinScheduler工作流失败", "instance_id", f.InstanceID, "project", f.Project,
+			"workflow", f.Workflow, "state", f.State, "failed_tasks", f.FailedTasks)
+		if NotifyPhones(ctx, "dolphinscheduler", f.Message()) == 0 {
+			job.RecordError(fmt.Errorf("instance_id=%d: 短信发送失败", f.InstanceID))
+			retry = true
+			if _, err := store.ExecContext(ctx, "DELETE FROM public.ds_alert_sent WHERE process_instance_id = $1", f.InstanceID); err != nil {
+				slog.ErrorContext(ctx, "撤销告警记录失败", "instance_id", f.InstanceID, "error", err)
+			}
+			continue
+		}
+		job.Add("alerts_sent", 1)
+		if !retry {
+			checkpoint, checkpointID = f.EndTime, f.InstanceID
+		}
+	}
+
+	if _, err := store.ExecContext(ctx, `INSERT INTO public.ds_alert_checkpoint (name, last_time, last_id, updated_at)
+		VALUES ($1, $2, $3, now()) ON CONFLICT (name) DO UPDATE
+		SET last_time = EXCLUDED.last_time, last_id = EXCLUDED.last_id, updated_at = now()`,
+		dsAlertCheckpoint, checkpoint, checkpointID); err != nil {
+		return fmt.Errorf("更新告警检查点失败: %w", err)
+	}
+	// 去重记录只需覆盖检查点附近的实例
+	if _, err := store.ExecContext(ctx, "DELETE FROM public.ds_alert_sent WHERE sent_at < now() - interval '7 days'"); err != nil {
+		slog.WarnContext(ctx, "清理告警记录失败", "error", err)
+	}
+	return nil
+}
+
+// dsAlertSince 读取检查点(最后处理的实例结束时间与ID)，首次运行时从DS_ALERT.LOOKBACK之前开始，避免历史失败集中告警
+func dsAlertSince(ctx context.Context, store *sql.DB) (time.Time, int64, error) {
+	var since time.Time
+	var id int64
+	err := store.QueryRowContext(ctx, "SELECT last_time, last_id FROM public.ds_alert_checkpoint WHERE name = $1",
+		dsAlertCheckpoint).Scan(&since, &id)
+	if err == sql.ErrNoRows {
+		return time.Now().Add(-viper.GetDuration("DS_ALERT.LOOKBACK")), 0, nil
+	}
+	if err != nil {
+		return since, 0, fmt.Errorf("读取告警检查点失败: %w", err)
+	}
+	return since, id, nil
+}
+
+// queryDSFailures 按(end_time, id)查询检查点之后的失败实例，按项目、工作流名称过滤；
+// 同一时刻结束的实例超过BATCH_SIZE时按id分页，不会反复取到同一批
+func queryDSFailures(ctx context.Context, db *sql.DB, driver string, since time.Time, sinceID int64) ([]DSFailure, error) {
+	states := viper.GetIntSlice("DS_ALERT.STATES")
+	if len(states) == 0 {
+		return nil, fmt.Errorf("DS_ALERT.STATES不能为空")
+	}
+	stateList := make([]string, len(states))
+	for i, s := range states {
+		stateList[i] = strconv.Itoa(s)
+	}
+
+	query := `select pi.id, pi.name, pi.state, pi.start_time, pi.end_time, coalesce(pi.host, ''), pd.name, p.name
+		from t_ds_process_instance pi
+		join t_ds_process_definition pd on pd.code = pi.process_definition_code
+		join t_ds_project p on p.code = pd.project_code
+		where pi.state in (` + strings.Join(stateList, ", ") + `)
+		and (pi.end_time > ? or (pi.end_time = ? and pi.id > ?))`
+	args := []any{dsTime(since), dsTime(since), sinceID}
+	if projects := viper.GetStringSlice("DS_ALERT.PROJECTS"); len(projects) > 0 {
+		query += " and p.name in (" + strings.TrimSuffix(strings.Repeat("?, ", len(projects)), ", ") + ")"
+		for _, p := range projects {
+			args = append(args, p)
+		}
+	}
+	if workflows := viper.GetStringSlice("DS_ALERT.WORKFLOWS"); len(workflows) > 0 {
+		query += " and pd.name in (" + strings.TrimSuffix(strings.Repeat("?, ", len(workflows)), ", ") + ")"
+		for _, w := range workflows {
+			args = append(args, w)
+		}
+	}
+	query += " order by pi.end_time, pi.id limit ?"
+	args = append(args, viper.GetInt("DS_ALERT.BATCH_SIZE"))
+
+	rows, err := db.QueryContext(ctx, rebind(driver, query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询失败实例失败: %w", err)
+	}
+	var failures []DSFailure
+	for rows.Next() {
+		var f DSFailure
+		var start, end sql.NullString
+		if err := rows.Scan(&f.InstanceID, &f.Instance, &f.State, &start, &end, &f.Host, &f.Workflow, &f.Project); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("扫描失败实例失败: %w", err)
+		}
+		f.StartTime, _ = parseDSTime(start.String)
+		if f.EndTime, err = parseDSTime(end.String); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("解析结束时间失败: %w", err)
+		}
+		failures = append(failures, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 失败的任务名称，任务实例状态与工作流实例一致
+	for i := range failures {
+		tasks, err := queryStrings(ctx, db, rebind(driver, `select name from t_ds_task_instance
+			where process_instance_id = ? and state in (`+strings.Join(stateList, ", ")+`) order by id`), failures[i].InstanceID)
+		if err != nil {
+			return nil, fmt.Errorf("查询失败任务失败: %w", err)
+		}
+		failures[i].FailedTasks = tasks
+	}
+	return failures, nil
+}
+
+// parseDSTime 解析DolphinScheduler时间字段：MySQL返回本地时间字符串；
+// Postgres的timestamp字段由驱动按UTC返回，实际为本地时间，只取其时钟值
+func parseDSTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local), nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05.999999", s, time.Local)
+}
@@ -0,0 +1,419 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// DolphinScheduler元数据维护的表
+const (
+	MaintainProcessInstance      = "process_instance"       // t_ds_process_instance，级联t_ds_task_instance、t_ds_relation_process_instance
+	MaintainCommand              = "command"                // t_ds_command
+	MaintainErrorCommand         = "error_command"          // t_ds_error_command
+	MaintainProcessDefinitionLog = "process_definition_log" // t_ds_process_definition_log，级联t_ds_process_task_relation_log
+)
+
+// maintenanceOrder 执行顺序：先清理实例与命令，再清理不再被实例引用的定义版本
+var maintenanceOrder = []string{MaintainCommand, MaintainErrorCommand, MaintainProcessInstance, MaintainProcessDefinitionLog}
+
+// MaintenancePolicy 单个表的保留策略，对应配置文件ds_maintenance.<table>
+type MaintenancePolicy struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	RetentionDays int           `mapstructure:"retention_days"` // 实例与命令类表：删除早于N天的记录
+	KeepVersions  int           `mapstructure:"keep_versions"`  // 定义版本表：每个定义保留最近N个版本
+	BatchSize     int           `mapstructure:"batch_size"`     // 每个事务最多删除的主表记录数
+	BatchSleep    time.Duration `mapstructure:"batch_sleep"`    // 批次间休眠，降低对调度器的影响
+}
+
+// defaultMaintenancePolicies 未配置时的默认值，t_ds_command中是待执行的命令，默认不清理
+var defaultMaintenancePolicies = map[string]MaintenancePolicy{
+	MaintainProcessInstance:      {Enabled: true, RetentionDays: 90, BatchSize: 500, BatchSleep: 200 * time.Millisecond},
+	MaintainCommand:              {Enabled: false, RetentionDays: 30, BatchSize: 500, BatchSleep: 200 * time.Millisecond},
+	MaintainErrorCommand:         {Enabled: true, RetentionDays: 30, BatchSize: 500, BatchSleep: 200 * time.Millisecond},
+	MaintainProcessDefinitionLog: {Enabled: true, KeepVersions: 20, BatchSize: 500, BatchSleep: 200 * time.Millisecond},
+}
+
+// finishedStates 已结束的工作流实例状态：停止、失败、成功、kill、强制成功。
+// 运行中、暂停、等待容错等状态的实例不清理
+const finishedStates = "5, 6, 7, 9, 13"
+
+// MaintenanceTableCount 单表删除(或dry-run时待删除)的行数
+type MaintenanceTableCount struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+// MaintenanceReport 元数据维护结果
+type MaintenanceReport struct {
+	DryRun bool                    `json:"dry_run"`
+	Driver string                  `json:"driver"`
+	Tables []MaintenanceTableCount `json:"tables"`
+}
+
+func (r *MaintenanceReport) add(table string, n int64) {
+	for i := range r.Tables {
+		if r.Tables[i].Table == table {
+			r.Tables[i].Rows += n
+			return
+		}
+	}
+	r.Tables = append(r.Tables, MaintenanceTableCount{Table: table, Rows: n})
+}
+
+// GetMaintenancePolicies 读取各表保留策略，未配置的字段使用默认值
+func GetMaintenancePolicies() (map[string]MaintenancePolicy, error) {
+	policies := make(map[string]MaintenancePolicy, len(defaultMaintenancePolicies))
+	for name, policy := range defaultMaintenancePolicies {
+		if err := viper.UnmarshalKey("ds_maintenance."+name, &policy); err != nil {
+			return nil, fmt.Errorf("解析ds_maintenance.%s配置失败: %w", name, err)
+		}
+		if policy.BatchSize <= 0 {
+			policy.BatchSize = 500
+		}
+		policies[name] = policy
+	}
+	for name := range viper.GetStringMap("ds_maintenance") {
+		if _, ok := defaultMaintenancePolicies[name]; !ok {
+			slog.Warn("ds_maintenance配置中的表不支持，已忽略", "table", name)
+		}
+	}
+	return policies, nil
+}
+
+// DSMaintenance 按保留策略清理DolphinScheduler元数据，db为DolphinScheduler连接池(MySQL或Postgres)。
+// 任务参数: dry_run=true只统计待删除行数
+func DSMaintenance(ctx context.Context, db *sql.DB) error {
+	job := JobFromContext(ctx)
+	cfg, err := DBs.Config(DBDolphinScheduler)
+	if err != nil {
+		return err
+	}
+	report, err := RunDSMaintenance(ctx, db, cfg.Driver, job.Param("dry_run") == "true")
+	job.SetResult(report)
+	return err
+}
+
+// RunDSMaintenance 依次清理各表，每批一个事务，子表记录与主表记录一起删除
+func RunDSMaintenance(ctx context.Context, db *sql.DB, driver string, dryRun bool) (*MaintenanceReport, error) {
+	report := &MaintenanceReport{DryRun: dryRun, Driver: driver, Tables: []MaintenanceTableCount{}}
+	policies, err := GetMaintenancePolicies()
+	if err != nil {
+		return report, err
+	}
+	m := &maintainer{db: db, driver: driver, dryRun: dryRun, report: report, job: JobFromContext(ctx)}
+	m.job.SetTotal(int64(len(maintenanceOrder)))
+
+	for _, name := range maintenanceOrder {
+		policy := policies[name]
+		if !policy.Enabled {
+			slog.InfoContext(ctx, "元数据维护未启用", "table", name)
+			continue
+		}
+		start := time.Now()
+		switch name {
+		case MaintainProcessInstance:
+			err = m.processInstances(ctx, policy)
+		case MaintainCommand:
+			err = m.commands(ctx, "t_ds_command", policy)
+		case MaintainErrorCommand:
+			err = m.commands(ctx, "t_ds_error_command", policy)
+		case MaintainProcessDefinitionLog:
+			err = m.processDefinitionLogs(ctx, policy)
+		}
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", name, err)
+		}
+		slog.InfoContext(ctx, "元数据维护完成", "table", name, "dry_run", dryRun, "duration", time.Since(start))
+		m.job.Step(1)
+	}
+	slog.InfoContext(ctx, "DolphinScheduler元数据维护完成", "dry_run", dryRun, "tables", report.Tables)
+	return report, nil
+}
+
+type maintainer struct {
+	db     *sql.DB
+	driver string
+	dryRun bool
+	report *MaintenanceReport
+	job    *Job
+}
+
+// count 记录删除行数，同时更新任务计数器
+func (m *maintainer) count(table string, n int64) {
+	m.report.add(table, n)
+	m.job.Add(table, n)
+}
+
+// processInstances 删除早于保留期且已结束的工作流实例及其任务实例、父子实例关系；
+// 仍被t_ds_command引用(如待恢复)的实例保留
+func (m *maintainer) processInstances(ctx context.Context, policy MaintenancePolicy) error {
+	cutoff := dsTime(time.Now().AddDate(0, 0, -policy.RetentionDays))
+	where := `end_time is not null and end_time < ? and state in (` + finishedStates + `)
+		and not exists (select 1 from t_ds_command c where c.process_instance_id = pi.id)`
+
+	if m.dryRun {
+		n, err := m.queryCount(ctx, "select count(1) from t_ds_process_instance pi where "+where, cutoff)
+		if err != nil {
+			return err
+		}
+		m.count("t_ds_process_instance", n)
+		n, err = m.queryCount(ctx, `select count(1) from t_ds_task_instance where process_instance_id in
+			(select id from t_ds_process_instance pi where `+where+`)`, cutoff)
+		if err != nil {
+			return err
+		}
+		m.count("t_ds_task_instance", n)
+		n, err = m.queryCount(ctx, `select count(1) from t_ds_relation_process_instance
+			where process_instance_id in (select id from t_ds_process_instance pi where `+where+`)
+			or parent_process_instance_id in (select id from t_ds_process_instance pi where `+where+`)`, cutoff, cutoff)
+		if err != nil {
+			return err
+		}
+		m.count("t_ds_relation_process_instance", n)
+		return nil
+	}
+
+	return m.deleteBatches(ctx, policy,
+		"select id from t_ds_process_instance pi where "+where+" order by id limit ?",
+		[]any{cutoff},
+		func(tx *sql.Tx, in string, ids []any) error {
+			n, err := m.exec(ctx, tx, "delete from t_ds_task_instance where process_instance_id in ("+in+")", ids...)
+			if err != nil {
+				return err
+			}
+			m.count("t_ds_task_instance", n)
+			n, err = m.exec(ctx, tx, "delete from t_ds_relation_process_instance where process_instance_id in ("+in+
+				") or parent_process_instance_id in ("+in+")", append(ids, ids...)...)
+			if err != nil {
+				return err
+			}
+			m.count("t_ds_relation_process_instance", n)
+			n, err = m.exec(ctx, tx, "delete from t_ds_process_instance where id in ("+in+")", ids...)
+			if err != nil {
+				return err
+			}
+			m.count("t_ds_process_instance", n)
+			return nil
+		})
+}
+
+// commands 删除update_time早于保留期的命令
+func (m *maintainer) commands(ctx context.Context, table string, policy MaintenancePolicy) error {
+	cutoff := dsTime(time.Now().AddDate(0, 0, -policy.RetentionDays))
+	if m.dryRun {
+		n, err := m.queryCount(ctx, "select count(1) from "+table+" where update_time < ?", cutoff)
+		if err != nil {
+			return err
+		}
+		m.count(table, n)
+		return nil
+	}
+	return m.deleteBatches(ctx, policy,
+		"select id from "+table+" where update_time < ? order by id limit ?",
+		[]any{cutoff},
+		func(tx *sql.Tx, in string, ids []any) error {
+			n, err := m.exec(ctx, tx, "delete from "+table+" where id in ("+in+")", ids...)
+			if err != nil {
+				return err
+			}
+			m.count(table, n)
+			return nil
+		})
+}
+
+// processDefinitionLogs 每个工作流定义保留最近KeepVersions个版本及其任务关系版本；
+// 当前版本和仍被工作流实例引用的版本不删除
+func (m *maintainer) processDefinitionLogs(ctx context.Context, policy MaintenancePolicy) error {
+	if policy.KeepVersions < 1 {
+		return fmt.Errorf("keep_versions必须大于0")
+	}
+	codes, err := m.queryStrings(ctx,
+		"select code from t_ds_process_definition_log group by code having count(1) > ?", policy.KeepVersions)
+	if err != nil {
+		return err
+	}
+
+	var sinceSleep int64
+	for _, code := range codes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		versions, err := m.staleVersions(ctx, code, policy.KeepVersions)
+		if err != nil {
+			return fmt.Errorf("code=%s: %w", code, err)
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		in, args := m.inArgs(len(versions)), append([]any{code}, versions...)
+
+		if m.dryRun {
+			m.count("t_ds_process_definition_log", int64(len(versions)))
+			n, err := m.queryCount(ctx, "select count(1) from t_ds_process_task_relation_log where process_definition_code = ? and process_definition_version in ("+in+")", args...)
+			if err != nil {
+				return err
+			}
+			m.count("t_ds_process_task_relation_log", n)
+			continue
+		}
+
+		err = m.inTx(ctx, func(tx *sql.Tx) error {
+			n, err := m.exec(ctx, tx, "delete from t_ds_process_task_relation_log where process_definition_code = ? and process_definition_version in ("+in+")", args...)
+			if err != nil {
+				return err
+			}
+			m.count("t_ds_process_task_relation_log", n)
+			n, err = m.exec(ctx, tx, "delete from t_ds_process_definition_log where code = ? and version in ("+in+")", args...)
+			if err != nil {
+				return err
+			}
+			m.count("t_ds_process_definition_log", n)
+			sinceSleep += n
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("code=%s: %w", code, err)
+		}
+		if sinceSleep >= int64(policy.BatchSize) {
+			sinceSleep = 0
+			if err := sleepContext(ctx, policy.BatchSleep); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// staleVersions 计算可删除的版本：最近keep个版本之外，且非当前版本、未被工作流实例引用
+func (m *maintainer) staleVersions(ctx context.Context, code string, keep int) ([]any, error) {
+	versions, err := m.queryStrings(ctx, "select version from t_ds_process_definition_log where code = ? order by version desc", code)
+	if err != nil || len(versions) <= keep {
+		return nil, err
+	}
+	inUse, err := m.queryStrings(ctx, `select version from t_ds_process_definition where code = ?
+		union select distinct process_definition_version from t_ds_process_instance where process_definition_code = ?`, code, code)
+	if err != nil {
+		return nil, err
+	}
+	var stale []any
+	for _, v := range versions[keep:] {
+		if !contains(inUse, v) {
+			stale = append(stale, v)
+		}
+	}
+	return stale, nil
+}
+
+// deleteBatches 循环查询最多BatchSize个主表id，在一个事务中删除子表与主表记录，直到没有可删除的记录
+func (m *maintainer) deleteBatches(ctx context.Context, policy MaintenancePolicy, selectIDs string, args []any,
+	del func(tx *sql.Tx, in string, ids []any) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ids, err := m.queryStrings(ctx, selectIDs, append(args, policy.BatchSize)...)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		batch := make([]any, len(ids))
+		for i, id := range ids {
+			batch[i] = id
+		}
+		if err := m.inTx(ctx, func(tx *sql.Tx) error {
+			return del(tx, m.inArgs(len(batch)), batch)
+		}); err != nil {
+			return err
+		}
+		if len(ids) < policy.BatchSize {
+			return nil
+		}
+		if err := sleepContext(ctx, policy.BatchSleep); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *maintainer) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *maintainer) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (int64, error) {
+	result, err := tx.ExecContext(ctx, rebind(m.driver, query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m *maintainer) queryCount(ctx context.Context, query string, args ...any) (int64, error) {
+	var n int64
+	err := m.db.QueryRowContext(ctx, rebind(m.driver, query), args...).Scan(&n)
+	return n, err
+}
+
+func (m *maintainer) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	return queryStrings(ctx, m.db, rebind(m.driver, query), args...)
+}
+
+// queryStrings 查询单列结果，按字符串读取以兼容MySQL与Postgres的整数类型
+func queryStrings(ctx context.Context, q queryer, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (m *maintainer) inArgs(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// rebind 将?占位符转换为Postgres的$N，SQL中不包含字符串字面量中的?
+func rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dsTime DolphinScheduler的时间字段为不带时区的本地时间，参数按本地时间格式传入，MySQL与Postgres通用
+func dsTime(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02 15:04:05.999999")
+}
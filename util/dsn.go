@@ -0,0 +1,235 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// connector 按驱动创建连接器，连接参数不经字符串拼接，密码中的特殊字符无需转义
+func (c DBConfig) connector() (driver.Connector, error) {
+	switch c.Driver {
+	case "mysql":
+		cfg, err := c.MySQLConfig()
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewConnector(cfg)
+	case "postgres":
+		cfg, err := c.PgxConfig()
+		if err != nil {
+			return nil, err
+		}
+		return stdlib.GetConnector(*cfg), nil
+	default:
+		return nil, fmt.Errorf("不支持的数据库驱动: %s", c.Driver)
+	}
+}
+
+// MySQLConfig 生成go-sql-driver/mysql连接配置，需要DSN字符串时使用其FormatDSN
+func (c DBConfig) MySQLConfig() (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = c.User
+	cfg.Passwd = c.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	cfg.DBName = c.Database
+	cfg.Timeout = c.ConnectTimeout
+	cfg.ReadTimeout = c.ReadTimeout
+	cfg.WriteTimeout = c.WriteTimeout
+	cfg.Params = map[string]string{}
+	if c.Charset != "" {
+		cfg.Params["charset"] = c.Charset
+	}
+	if c.Timezone != "" {
+		loc, err := c.location()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Loc = loc
+		// 使用时区名而不是启动时的UTC偏移，夏令时切换后仍然正确；MySQL服务端需已加载时区表(mysql_tzinfo_to_sql)
+		cfg.Params["time_zone"] = "'" + loc.String() + "'"
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLS = tlsConfig
+	return cfg, nil
+}
+
+// PostgresDSN 生成libpq的URL形式连接串，各字段经URL转义，由PgxConfig解析
+func (c DBConfig) PostgresDSN() string {
+	q := url.Values{}
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	q.Set("sslmode", sslMode)
+	if c.SSLRootCert != "" {
+		q.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		q.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		q.Set("sslkey", c.SSLKey)
+	}
+	if c.ConnectTimeout > 0 {
+		// connect_timeout单位为秒
+		q.Set("connect_timeout", strconv.Itoa(int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+	if c.Timezone != "" {
+		q.Set("timezone", c.Timezone)
+	}
+	q.Set("application_name", "webhook")
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Database,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// PgxConfig 生成pgx连接配置。database/sql连接池(pgx stdlib)与COPY等直接使用pgx的操作共用该配置
+func (c DBConfig) PgxConfig() (*pgx.ConnConfig, error) {
+	if c.Timezone != "" {
+		if _, err := c.location(); err != nil {
+			return nil, err
+		}
+	}
+	return pgx.ParseConfig(c.PostgresDSN())
+}
+
+// location 解析TIMEZONE。Local取决于运行环境且没有对应的时区名，MySQL与Postgres都不识别，需写明时区名
+func (c DBConfig) location() (*time.Location, error) {
+	if c.Timezone == "Local" {
+		return nil, fmt.Errorf("TIMEZONE不能为Local，请使用时区名，如Asia/Shanghai")
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("无效的时区%s: %w", c.Timezone, err)
+	}
+	return loc, nil
+}
+
+// MySQLJDBCURL Seatunnel等JVM客户端使用的MySQL Connector/J连接串，不含用户名密码。
+// SSLMODE映射为sslMode；Connector/J只接受JKS/PKCS12信任库，SSLROOTCERT不传入，
+// verify-ca/verify-full时CA需导入JVM信任库
+func (c DBConfig) MySQLJDBCURL() string {
+	q := url.Values{}
+	switch c.SSLMode {
+	case "disable":
+		q.Set("sslMode", "DISABLED")
+	case "require":
+		q.Set("sslMode", "REQUIRED")
+	case "verify-ca":
+		q.Set("sslMode", "VERIFY_CA")
+	case "verify-full":
+		q.Set("sslMode", "VERIFY_IDENTITY")
+	}
+	if c.Timezone != "" {
+		q.Set("connectionTimeZone", c.Timezone)
+	}
+	return jdbcURL("mysql", c, q)
+}
+
+// PostgresJDBCURL Seatunnel等JVM客户端使用的pgjdbc连接串，不含用户名密码，SSL参数与PostgresDSN一致
+func (c DBConfig) PostgresJDBCURL() string {
+	q := url.Values{}
+	if c.SSLMode != "" {
+		q.Set("sslmode", c.SSLMode)
+	}
+	if c.SSLRootCert != "" {
+		q.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		q.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		// pgjdbc的客户端私钥需为PKCS-8 DER格式
+		q.Set("sslkey", c.SSLKey)
+	}
+	q.Set("ApplicationName", "webhook")
+	return jdbcURL("postgresql", c, q)
+}
+
+func jdbcURL(scheme string, c DBConfig, q url.Values) string {
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Database,
+		RawQuery: q.Encode(),
+	}
+	return "jdbc:" + u.String()
+}
+
+// tlsConfig 按SSLMode生成MySQL的TLS配置，语义与Postgres的sslmode一致
+func (c DBConfig) tlsConfig() (*tls.Config, error) {
+	switch c.SSLMode {
+	case "", "disable":
+		return nil, nil
+	case "require", "verify-ca", "verify-full":
+	default:
+		return nil, fmt.Errorf("无效的SSLMODE: %s", c.SSLMode)
+	}
+
+	conf := &tls.Config{ServerName: c.Host, MinVersion: tls.VersionTLS12}
+	if c.SSLCert != "" || c.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.SSLCert, c.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if c.SSLMode == "require" {
+		// 只加密不校验证书
+		conf.InsecureSkipVerify = true
+		return conf, nil
+	}
+
+	var roots *x509.CertPool
+	if c.SSLRootCert != "" {
+		pem, err := os.ReadFile(c.SSLRootCert)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA证书%s中没有有效证书", c.SSLRootCert)
+		}
+		conf.RootCAs = roots
+	}
+	if c.SSLMode == "verify-ca" {
+		// 校验证书链，不校验主机名
+		conf.InsecureSkipVerify = true
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("服务端未提供证书")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return conf, nil
+}
@@ -0,0 +1,189 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// 依赖检查状态
+const (
+	DepUp      = "up"
+	DepDown    = "down"
+	DepSkipped = "skipped" // 未配置
+)
+
+// DependencyStatus 单个依赖的检查结果
+type DependencyStatus struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Required  bool      `json:"required"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// ReadinessReport 就绪检查结果
+type ReadinessReport struct {
+	Ready        bool               `json:"ready"`
+	Cached       bool               `json:"cached"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error // 返回errSkipped表示未配置
+}
+
+var errSkipped = errors.New("未配置")
+
+// HealthChecker 检查外部依赖的可达性，结果缓存HEALTH.CACHE_TTL避免探测打满依赖
+type HealthChecker struct {
+	checks []dependencyCheck
+
+	mu        sync.Mutex
+	last      []DependencyStatus
+	checkedAt time.Time
+}
+
+// Health 全局依赖检查
+var Health = NewHealthChecker()
+
+// NewHealthChecker 创建依赖检查：DolphinScheduler MySQL、pg_struct、Dify、短信网关
+func NewHealthChecker() *HealthChecker {
+	h := &HealthChecker{}
+	h.checks = []dependencyCheck{
+		{name: "dolphinscheduler_mysql", check: checkDB(DBDolphinScheduler)},
+		{name: "pg_struct", check: checkDB(DBPgStruct)},
+		{name: "dify", check: checkDify},
+		{name: "sms_gateway", check: checkSmsGateway},
+	}
+	return h
+}
+
+// Check 返回依赖检查结果，缓存未过期时直接返回缓存
+func (h *HealthChecker) Check(ctx context.Context) ReadinessReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cached := true
+	if h.last == nil || time.Since(h.checkedAt) > viper.GetDuration("HEALTH.CACHE_TTL") {
+		// 探测请求断开不应让缓存结果变成down
+		h.last = h.runChecks(context.WithoutCancel(ctx))
+		h.checkedAt = time.Now()
+		cached = false
+	}
+
+	report := ReadinessReport{Ready: true, Cached: cached, Dependencies: h.last}
+	for _, dep := range h.last {
+		if dep.Required && dep.Status == DepDown {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// runChecks 并发检查所有依赖，每个依赖单独超时
+func (h *HealthChecker) runChecks(ctx context.Context) []DependencyStatus {
+	timeout := viper.GetDuration("HEALTH.TIMEOUT")
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	optional := viper.GetStringSlice("HEALTH.OPTIONAL")
+
+	results := make([]DependencyStatus, len(h.checks))
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(checkCtx)
+			dep := DependencyStatus{
+				Name:      c.name,
+				Status:    DepUp,
+				Required:  !contains(optional, c.name),
+				LatencyMs: time.Since(start).Milliseconds(),
+				CheckedAt: start,
+			}
+			switch {
+			case errors.Is(err, errSkipped):
+				dep.Status = DepSkipped
+			case err != nil:
+				dep.Status = DepDown
+				dep.Error = err.Error()
+			}
+			results[i] = dep
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkDB 通过共享连接池检查命名数据库连接，未配置时跳过
+func checkDB(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		err := DBs.Ping(ctx, name)
+		if errors.Is(err, ErrDBNotConfigured) {
+			return errSkipped
+		}
+		return err
+	}
+}
+
+// checkDify 请求Dify服务地址，收到任何HTTP响应即视为可达，5xx视为不可用
+func checkDify(ctx context.Context) error {
+	baseURL := viper.GetString("DIFY_API_BASE_URL")
+	if baseURL == "" {
+		return errSkipped
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkSmsGateway 只检查短信网关端口是否可连接，避免调用SOAP接口产生副作用
+func checkSmsGateway(ctx context.Context) error {
+	raw := viper.GetString("SMS_PLATFORM_URL")
+	if raw == "" {
+		return errSkipped
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
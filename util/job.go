@@ -0,0 +1,663 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 内置任务类型
+const (
+	JobDelMysql         = "del_mysql"
+	JobDelHistory       = "del_history"
+	JobProcessVisits    = "process_visits"
+	JobProcessMZ        = "process_mz"
+	JobValidatePatient  = "validate_patient"
+	JobSeatunnelMysqlPg = "seatunnel_mysql_pg"
+	JobDSMaintenance    = "ds_maintenance"
+	JobDSAlert          = "ds_alert"
+	JobSchemaSync       = "schema_sync"
+	JobSyncVerify       = "sync_verify"
+)
+
+// 任务触发来源
+const (
+	TriggerCron = "cron"
+	TriggerAPI  = "api"
+)
+
+const (
+	maxJobErrors    = 100 // 单个任务最多保留的错误条数
+	maxFinishedJobs = 200 // 内存中最多保留的任务数
+)
+
+// JobStatus 任务状态
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+	JobSkipped   JobStatus = "skipped" // 其他实例正在执行
+)
+
+// ConflictPolicy 同类型任务已在运行时的处理策略
+type ConflictPolicy string
+
+const (
+	ConflictReject ConflictPolicy = "reject" // 直接拒绝
+	ConflictQueue  ConflictPolicy = "queue"  // 排队等待上一个结束
+)
+
+// ErrUnknownJob 未注册的任务类型
+var ErrUnknownJob = errors.New("未注册的任务类型")
+
+// JobConflictError 同类型任务正在运行
+type JobConflictError struct {
+	JobType      string
+	RunningJobID string
+}
+
+func (e *JobConflictError) Error() string {
+	return fmt.Sprintf("任务 %s 正在运行 (job_id=%s)", e.JobType, e.RunningJobID)
+}
+
+// JobFunc 任务执行函数，需响应ctx取消
+type JobFunc func(ctx context.Context) error
+
+// JobDefinition 任务注册信息
+type JobDefinition struct {
+	Name    string
+	Run     JobFunc
+	Policy  ConflictPolicy // 为空时使用JOB_CONFLICT_POLICY配置
+	Timeout time.Duration  // 默认超时，提交时未指定则使用
+}
+
+// JobOptions 提交任务时的参数
+type JobOptions struct {
+	Trigger   string            // cron / api
+	Timeout   time.Duration     // 0表示不限制
+	Params    map[string]string // 任务自定义参数
+	RequestID string            // 触发任务的HTTP请求ID，用于日志关联
+	TraceLink trace.SpanContext // 触发任务的请求span，任务span通过link关联
+}
+
+// Job 一次任务执行
+type Job struct {
+	ID        string
+	Type      string
+	Trigger   string
+	Params    map[string]string
+	RequestID string
+
+	mu         sync.Mutex
+	status     JobStatus
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	total      int64
+	done       int64
+	counters   map[string]int64
+	errors     []string
+	errorCount int64
+	err        error
+	result     any
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneCh chan struct{}
+	span   trace.Span
+}
+
+// JobView 任务状态快照，用于API返回
+type JobView struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Trigger    string            `json:"trigger"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Status     JobStatus         `json:"status"`
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Duration   string            `json:"duration,omitempty"`
+	Progress   JobProgress       `json:"progress"`
+	Counters   map[string]int64  `json:"counters"`
+	Errors     []string          `json:"errors,omitempty"`
+	ErrorCount int64             `json:"error_count"`
+	Error      string            `json:"error,omitempty"`
+	Result     any               `json:"result,omitempty"`
+}
+
+// JobProgress 任务进度
+type JobProgress struct {
+	Total int64 `json:"total"`
+	Done  int64 `json:"done"`
+}
+
+type jobCtxKey struct{}
+
+// JobFromContext 获取ctx中携带的任务，不在任务中执行时返回nil（Job的方法均可安全用于nil）
+func JobFromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobCtxKey{}).(*Job)
+	return job
+}
+
+// SetTotal 设置任务总量
+func (j *Job) SetTotal(n int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.total = n
+	j.mu.Unlock()
+}
+
+// AddTotal 增加任务总量，用于分批发现待处理数据的任务
+func (j *Job) AddTotal(n int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.total += n
+	j.mu.Unlock()
+}
+
+// Step 增加已完成数量
+func (j *Job) Step(n int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.done += n
+	j.mu.Unlock()
+}
+
+// Add 累加计数器
+func (j *Job) Add(name string, delta int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.counters[name] += delta
+	j.mu.Unlock()
+}
+
+// RecordError 记录一条非致命错误
+func (j *Job) RecordError(err error) {
+	if j == nil || err == nil {
+		return
+	}
+	j.mu.Lock()
+	j.errorCount++
+	if len(j.errors) < maxJobErrors {
+		j.errors = append(j.errors, err.Error())
+	}
+	j.mu.Unlock()
+}
+
+// SetResult 设置任务结果，随任务状态一起返回，如DelMysql的删除计划
+func (j *Job) SetResult(v any) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.result = v
+	j.mu.Unlock()
+}
+
+// Param 读取任务参数
+func (j *Job) Param(name string) string {
+	if j == nil {
+		return ""
+	}
+	return j.Params[name]
+}
+
+// Done 任务结束时关闭
+func (j *Job) Done() <-chan struct{} {
+	return j.doneCh
+}
+
+// Status 当前状态
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Err 任务返回的错误
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// View 生成状态快照
+func (j *Job) View() JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := JobView{
+		ID:         j.ID,
+		Type:       j.Type,
+		Trigger:    j.Trigger,
+		RequestID:  j.RequestID,
+		Params:     j.Params,
+		Status:     j.status,
+		CreatedAt:  j.createdAt,
+		Progress:   JobProgress{Total: j.total, Done: j.done},
+		Counters:   make(map[string]int64, len(j.counters)),
+		Errors:     append([]string(nil), j.errors...),
+		ErrorCount: j.errorCount,
+		Result:     j.result,
+	}
+	for k, c := range j.counters {
+		v.Counters[k] = c
+	}
+	if !j.startedAt.IsZero() {
+		started := j.startedAt
+		v.StartedAt = &started
+		end := time.Now()
+		if !j.finishedAt.IsZero() {
+			finished := j.finishedAt
+			v.FinishedAt = &finished
+			end = finished
+		}
+		v.Duration = end.Sub(started).Round(time.Millisecond).String()
+	}
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+	return v
+}
+
+// JobListener 任务开始与结束的回调，在任务goroutine中同步调用
+type JobListener interface {
+	JobStarted(v JobView)
+	JobFinished(v JobView)
+}
+
+// JobManager 后台任务管理器，保证同类型任务不会重叠执行
+type JobManager struct {
+	mu        sync.Mutex
+	defs      map[string]JobDefinition
+	slots     map[string]chan struct{}
+	running   map[string]*Job
+	jobs      map[string]*Job
+	listeners []JobListener
+	locker    Locker
+}
+
+// Jobs 全局任务管理器，API与定时任务共用
+var Jobs = NewJobManager()
+
+// NewJobManager 创建任务管理器
+func NewJobManager() *JobManager {
+	return &JobManager{
+		defs:    make(map[string]JobDefinition),
+		slots:   make(map[string]chan struct{}),
+		running: make(map[string]*Job),
+		jobs:    make(map[string]*Job),
+	}
+}
+
+// Register 注册任务类型
+func (m *JobManager) Register(def JobDefinition) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defs[def.Name] = def
+	if _, ok := m.slots[def.Name]; !ok {
+		m.slots[def.Name] = make(chan struct{}, 1)
+	}
+}
+
+// SetTimeout 设置任务类型的默认超时
+func (m *JobManager) SetTimeout(jobType string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if def, ok := m.defs[jobType]; ok {
+		def.Timeout = d
+		m.defs[jobType] = def
+	}
+}
+
+// SetLocker 设置分布式锁，多实例部署时同一任务同一时刻只在一个实例上执行
+func (m *JobManager) SetLocker(l Locker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locker = l
+}
+
+// AddListener 添加任务回调
+func (m *JobManager) AddListener(l JobListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, l)
+}
+
+func (m *JobManager) notify(job *Job, finished bool) {
+	m.mu.Lock()
+	listeners := append([]JobListener(nil), m.listeners...)
+	m.mu.Unlock()
+
+	v := job.View()
+	for _, l := range listeners {
+		if finished {
+			l.JobFinished(v)
+		} else {
+			l.JobStarted(v)
+		}
+	}
+}
+
+// Types 已注册的任务类型
+func (m *JobManager) Types() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.defs))
+	for name := range m.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Submit 提交任务，立即返回；同类型任务运行中时按策略拒绝或排队
+func (m *JobManager) Submit(jobType string, opts JobOptions) (*Job, error) {
+	m.mu.Lock()
+	def, ok := m.defs[jobType]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJob, jobType)
+	}
+	slot := m.slots[jobType]
+
+	policy := def.Policy
+	if policy == "" {
+		policy = ConflictPolicy(viper.GetString("JOB_CONFLICT_POLICY"))
+	}
+	if policy != ConflictQueue {
+		policy = ConflictReject
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = def.Timeout
+	}
+	job := newJob(jobType, opts)
+	if policy == ConflictReject {
+		select {
+		case slot <- struct{}{}:
+		default:
+			running := m.running[jobType]
+			m.mu.Unlock()
+			runningID := ""
+			if running != nil {
+				runningID = running.ID
+			}
+			conflict := &JobConflictError{JobType: jobType, RunningJobID: runningID}
+			// 被拒绝的任务不会运行，结束已创建的span
+			endSpan(job.span, conflict)
+			job.cancel()
+			return nil, conflict
+		}
+		m.running[jobType] = job
+	}
+	m.jobs[job.ID] = job
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	go m.run(def, job, slot, policy)
+	return job, nil
+}
+
+// Get 按ID查找任务
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List 按创建时间倒序列出内存中的任务
+func (m *JobManager) List() []JobView {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.Unlock()
+
+	views := make([]JobView, 0, len(jobs))
+	for _, job := range jobs {
+		views = append(views, job.View())
+	}
+	sort.Slice(views, func(a, b int) bool { return views[a].CreatedAt.After(views[b].CreatedAt) })
+	return views
+}
+
+// Cancel 取消任务，返回任务是否存在
+func (m *JobManager) Cancel(id string) bool {
+	job, ok := m.Get(id)
+	if !ok {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+func newJob(jobType string, opts JobOptions) *Job {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	job := &Job{
+		ID:        newJobID(),
+		Type:      jobType,
+		Trigger:   opts.Trigger,
+		Params:    opts.Params,
+		RequestID: opts.RequestID,
+		status:    JobQueued,
+		createdAt: time.Now(),
+		counters:  make(map[string]int64),
+		cancel:    cancel,
+		doneCh:    make(chan struct{}),
+	}
+	if opts.RequestID != "" {
+		ctx = WithRequestID(ctx, opts.RequestID)
+	}
+
+	// 任务运行时间远长于触发请求，单独作为一条trace，通过link关联触发请求
+	spanOpts := []trace.SpanStartOption{trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.String("job.type", jobType),
+		attribute.String("job.trigger", opts.Trigger),
+	)}
+	if opts.TraceLink.IsValid() {
+		spanOpts = append(spanOpts, trace.WithLinks(trace.Link{SpanContext: opts.TraceLink}))
+	}
+	ctx, job.span = tracer.Start(ctx, "job "+jobType, append(spanOpts, trace.WithNewRoot())...)
+	job.ctx = context.WithValue(ctx, jobCtxKey{}, job)
+	return job
+}
+
+// run 在独立goroutine中执行任务，reject策略下Submit已占用同类型任务槽位
+func (m *JobManager) run(def JobDefinition, job *Job, slot chan struct{}, policy ConflictPolicy) {
+	defer close(job.doneCh)
+	defer job.cancel()
+
+	if policy == ConflictQueue {
+		select {
+		case slot <- struct{}{}:
+		case <-job.ctx.Done():
+			m.finish(job, job.ctx.Err())
+			return
+		}
+		m.mu.Lock()
+		m.running[def.Name] = job
+		m.mu.Unlock()
+	}
+	defer func() {
+		m.mu.Lock()
+		if m.running[def.Name] == job {
+			delete(m.running, def.Name)
+		}
+		m.mu.Unlock()
+		<-slot
+	}()
+
+	// 多实例部署时通过分布式锁保证同一任务只在一个实例上执行
+	lock, err := m.acquireLock(job, policy == ConflictQueue)
+	if err != nil {
+		m.finish(job, err)
+		return
+	}
+	defer lock.Release()
+	go func() {
+		select {
+		case <-lock.Lost():
+			slog.WarnContext(job.ctx, "任务的分布式锁丢失，取消任务")
+			job.RecordError(errors.New("分布式锁丢失"))
+			job.cancel()
+		case <-job.ctx.Done():
+		}
+	}()
+
+	job.mu.Lock()
+	job.status = JobRunning
+	job.startedAt = time.Now()
+	job.mu.Unlock()
+	slog.InfoContext(job.ctx, "开始执行任务", "trigger", job.Trigger)
+	m.notify(job, false)
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		err = def.Run(job.ctx)
+	}()
+	m.finish(job, err)
+}
+
+// acquireLock 获取任务的分布式锁，wait为true时等待其他实例执行结束
+func (m *JobManager) acquireLock(job *Job, wait bool) (Lock, error) {
+	m.mu.Lock()
+	locker := m.locker
+	m.mu.Unlock()
+	if locker == nil {
+		locker = localLocker{}
+	}
+
+	for {
+		lock, err := locker.TryLock(job.ctx, "job:"+job.Type)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, ErrLockHeld) || !wait {
+			return nil, err
+		}
+		if err := sleepContext(job.ctx, lockRetryInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (m *JobManager) finish(job *Job, err error) {
+	job.mu.Lock()
+	job.finishedAt = time.Now()
+	if job.startedAt.IsZero() {
+		job.startedAt = job.finishedAt
+	}
+	job.err = err
+	switch {
+	case err == nil:
+		job.status = JobSucceeded
+	case errors.Is(err, ErrLockHeld):
+		job.status = JobSkipped
+	case errors.Is(job.ctx.Err(), context.Canceled):
+		job.status = JobCanceled
+	default:
+		job.status = JobFailed
+	}
+	status := job.status
+	duration := job.finishedAt.Sub(job.startedAt)
+	counters := make([]attribute.KeyValue, 0, len(job.counters)+2)
+	for name, v := range job.counters {
+		counters = append(counters, attribute.Int64("job.counter."+name, v))
+	}
+	counters = append(counters, attribute.String("job.status", string(status)), attribute.Int64("job.error_count", job.errorCount))
+	job.mu.Unlock()
+
+	job.span.SetAttributes(counters...)
+	if status == JobFailed {
+		endSpan(job.span, err)
+	} else {
+		job.span.End()
+	}
+
+	if err != nil {
+		slog.WarnContext(job.ctx, "任务结束", "status", status, "duration", duration, "error", err)
+	} else {
+		slog.InfoContext(job.ctx, "任务执行完成", "status", status, "duration", duration)
+	}
+	m.notify(job, true)
+}
+
+// pruneLocked 清理过多的已结束任务，调用方需持有m.mu
+func (m *JobManager) pruneLocked() {
+	if len(m.jobs) <= maxFinishedJobs {
+		return
+	}
+	var finished []*Job
+	for _, job := range m.jobs {
+		select {
+		case <-job.doneCh:
+			finished = append(finished, job)
+		default:
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].createdAt.Before(finished[b].createdAt) })
+	for i := 0; i < len(finished) && len(m.jobs) > maxFinishedJobs; i++ {
+		delete(m.jobs, finished[i].ID)
+	}
+}
+
+// StatusURL 任务状态查询地址
+func StatusURL(jobID string) string {
+	return "/api/jobs/" + jobID
+}
+
+func newJobID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b)
+}
+
+// sleepContext 可被ctx取消的休眠
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
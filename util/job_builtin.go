@@ -0,0 +1,34 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+)
+
+// RegisterBuiltinJobs 注册内置任务，API与定时任务均通过任务管理器触发，数据库连接从DBs获取
+func RegisterBuiltinJobs() {
+	Jobs.Register(JobDefinition{Name: JobDelMysql, Run: withDB(DBDolphinScheduler, DelMysql)})
+	Jobs.Register(JobDefinition{Name: JobDelHistory, Run: DelHistory})
+	Jobs.Register(JobDefinition{Name: JobDSMaintenance, Run: withDB(DBDolphinScheduler, DSMaintenance)})
+	Jobs.Register(JobDefinition{Name: JobDSAlert, Run: withDB(DBDolphinScheduler, DSAlert)})
+	Jobs.Register(JobDefinition{Name: JobProcessVisits, Run: withDB(DBPgStruct, ProcessVisits)})
+	Jobs.Register(JobDefinition{Name: JobProcessMZ, Run: withDB(DBPgStruct, ProcessMZMain)})
+	Jobs.Register(JobDefinition{Name: JobValidatePatient, Run: withDB(DBPgStruct, ValidatePatientData)})
+	Jobs.Register(JobDefinition{Name: JobSeatunnelMysqlPg, Run: withDB(DBMySQLSrc, func(ctx context.Context, db *sql.DB) error {
+		src, err := DBs.Config(DBMySQLSrc)
+		if err != nil {
+			return err
+		}
+		tgt, err := DBs.Config(DBPostgresTgt)
+		if err != nil {
+			return err
+		}
+		tgtDB, err := DBs.Get(DBPostgresTgt)
+		if err != nil {
+			return err
+		}
+		return SyncMySQLToPG(ctx, db, tgtDB, src, tgt)
+	})})
+	Jobs.Register(JobDefinition{Name: JobSchemaSync, Run: withDB(DBMySQLSrc, SchemaSync)})
+	Jobs.Register(JobDefinition{Name: JobSyncVerify, Run: withDB(DBMySQLSrc, SyncVerify)})
+}
@@ -0,0 +1,234 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// JobRun job_runs表中的一次任务执行记录
+type JobRun struct {
+	ID         string           `json:"id"`
+	JobType    string           `json:"job_type"`
+	Trigger    string           `json:"trigger"`
+	RequestID  string           `json:"request_id,omitempty"`
+	Status     string           `json:"status"`
+	Host       string           `json:"host"`
+	Params     json.RawMessage  `json:"params,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	DurationMs *int64           `json:"duration_ms,omitempty"`
+	Counters   map[string]int64 `json:"counters"`
+	ErrorCount int64            `json:"error_count"`
+	Error      string           `json:"error,omitempty"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+// JobRunQuery 任务历史查询条件
+type JobRunQuery struct {
+	JobType  string
+	Status   string
+	Trigger  string
+	Page     int
+	PageSize int
+}
+
+// JobHistory 任务执行历史存储，写入pg_struct库的job_runs表
+type JobHistory struct {
+	db   *sql.DB
+	host string
+}
+
+// History 全局任务历史，未启用时为nil
+var History *JobHistory
+
+const createJobRunsSQL = `
+CREATE TABLE IF NOT EXISTS public.job_runs (
+	id          varchar(64) PRIMARY KEY,
+	job_type    varchar(64) NOT NULL,
+	trigger     varchar(16) NOT NULL,
+	request_id  varchar(64),
+	status      varchar(16) NOT NULL,
+	host        varchar(128) NOT NULL,
+	params      jsonb,
+	started_at  timestamptz NOT NULL,
+	finished_at timestamptz,
+	duration_ms bigint,
+	counters    jsonb NOT NULL DEFAULT '{}',
+	error_count bigint NOT NULL DEFAULT 0,
+	error       text,
+	errors      jsonb
+);
+ALTER TABLE public.job_runs ADD COLUMN IF NOT EXISTS request_id varchar(64);
+CREATE INDEX IF NOT EXISTS idx_job_runs_started_at ON public.job_runs (started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_job_runs_job_type ON public.job_runs (job_type, started_at DESC);`
+
+// InitJobHistory 连接pg_struct并创建job_runs表，注册为任务回调
+func InitJobHistory() error {
+	if !viper.GetBool("JOB_HISTORY.ENABLED") {
+		slog.Info("任务历史记录未启用")
+		return nil
+	}
+
+	// 只支持pg_struct存储，不可用时任务状态只保留在内存中
+	db, err := DBs.Get(DBPgStruct)
+	if err != nil {
+		return fmt.Errorf("任务历史数据库不可用，任务历史记录已停用: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, createJobRunsSQL); err != nil {
+		return fmt.Errorf("创建job_runs表失败，任务历史记录已停用: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	h := &JobHistory{db: db, host: host}
+
+	// 本机上次异常退出时未结束的记录标记为中断
+	res, err := db.ExecContext(ctx, `
+		UPDATE public.job_runs SET status = 'interrupted', finished_at = now(), error = '服务重启，任务中断'
+		WHERE host = $1 AND status IN ('queued', 'running')`, host)
+	if err != nil {
+		slog.Error("更新中断任务失败", "error", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		slog.Warn("未结束的任务记录已标记为中断", "count", n)
+	}
+
+	History = h
+	Jobs.AddListener(h)
+	slog.Info("任务历史记录已启用")
+	return nil
+}
+
+// JobStarted 任务开始时插入记录
+func (h *JobHistory) JobStarted(v JobView) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	params, _ := json.Marshal(v.Params)
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO public.job_runs (id, job_type, trigger, request_id, status, host, params, started_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8)
+		ON CONFLICT (id) DO NOTHING`,
+		v.ID, v.Type, v.Trigger, v.RequestID, string(v.Status), h.host, string(params), startedAt(v))
+	if err != nil {
+		slog.Error("写入任务历史失败", "job_id", v.ID, "error", err)
+	}
+}
+
+// JobFinished 任务结束时更新结果、计数和错误
+func (h *JobHistory) JobFinished(v JobView) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	params, _ := json.Marshal(v.Params)
+	counters, _ := json.Marshal(v.Counters)
+	errs, _ := json.Marshal(v.Errors)
+	var durationMs *int64
+	var finishedAt *time.Time
+	if v.FinishedAt != nil {
+		finishedAt = v.FinishedAt
+		d := v.FinishedAt.Sub(startedAt(v)).Milliseconds()
+		durationMs = &d
+	}
+
+	// 排队中被取消的任务没有开始记录，使用upsert
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO public.job_runs (id, job_type, trigger, request_id, status, host, params, started_at,
+			finished_at, duration_ms, counters, error_count, error, errors)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), $14)
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			finished_at = EXCLUDED.finished_at,
+			duration_ms = EXCLUDED.duration_ms,
+			counters = EXCLUDED.counters,
+			error_count = EXCLUDED.error_count,
+			error = EXCLUDED.error,
+			errors = EXCLUDED.errors`,
+		v.ID, v.Type, v.Trigger, v.RequestID, string(v.Status), h.host, string(params), startedAt(v),
+		finishedAt, durationMs, string(counters), v.ErrorCount, v.Error, string(errs))
+	if err != nil {
+		slog.Error("更新任务历史失败", "job_id", v.ID, "error", err)
+	}
+}
+
+// List 分页查询任务历史，按开始时间倒序
+func (h *JobHistory) List(ctx context.Context, q JobRunQuery) ([]JobRun, int64, error) {
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.PageSize <= 0 || q.PageSize > 500 {
+		q.PageSize = 20
+	}
+
+	var conds []string
+	var args []interface{}
+	for col, val := range map[string]string{"job_type": q.JobType, "status": q.Status, "trigger": q.Trigger} {
+		if val == "" {
+			continue
+		}
+		args = append(args, val)
+		conds = append(conds, fmt.Sprintf("%s = $%d", col, len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int64
+	if err := h.db.QueryRowContext(ctx, "SELECT count(*) FROM public.job_runs "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, q.PageSize, (q.Page-1)*q.PageSize)
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, job_type, trigger, COALESCE(request_id, ''), status, host, COALESCE(params::text, 'null'), started_at, finished_at,
+			duration_ms, counters::text, error_count, COALESCE(error, ''), COALESCE(errors::text, 'null')
+		FROM public.job_runs %s
+		ORDER BY started_at DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	runs := []JobRun{}
+	for rows.Next() {
+		var r JobRun
+		var params, counters, errs string
+		var finishedAt sql.NullTime
+		var durationMs sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.JobType, &r.Trigger, &r.RequestID, &r.Status, &r.Host, &params, &r.StartedAt,
+			&finishedAt, &durationMs, &counters, &r.ErrorCount, &r.Error, &errs); err != nil {
+			return nil, 0, err
+		}
+		if params != "null" {
+			r.Params = json.RawMessage(params)
+		}
+		if finishedAt.Valid {
+			r.FinishedAt = &finishedAt.Time
+		}
+		if durationMs.Valid {
+			r.DurationMs = &durationMs.Int64
+		}
+		_ = json.Unmarshal([]byte(counters), &r.Counters)
+		_ = json.Unmarshal([]byte(errs), &r.Errors)
+		runs = append(runs, r)
+	}
+	return runs, total, rows.Err()
+}
+
+func startedAt(v JobView) time.Time {
+	if v.StartedAt != nil {
+		return *v.StartedAt
+	}
+	return v.CreatedAt
+}
@@ -0,0 +1,440 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LLM回答解析失败的类型
+const (
+	LLMErrNoJSON = "no_json" // 回答中没有JSON
+	LLMErrSyntax = "syntax"  // JSON修复后仍无法解析
+	LLMErrSchema = "schema"  // JSON与声明的结构不符
+)
+
+// LLMParseError 模型回答解析失败，Path为不符合结构的字段路径，如[3].code
+type LLMParseError struct {
+	Kind    string
+	Path    string
+	Message string
+}
+
+func (e *LLMParseError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("解析模型回答失败(%s) %s: %s", e.Kind, e.Path, e.Message)
+	}
+	return fmt.Sprintf("解析模型回答失败(%s): %s", e.Kind, e.Message)
+}
+
+// LLMField 对象的字段，Type为string/integer/number/boolean/array/object
+type LLMField struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// LLMSchema 期望的回答结构，Type为object时Fields为对象字段，为array时Fields为元素字段
+type LLMSchema struct {
+	Type   string
+	Fields []LLMField
+}
+
+// reasoningBlock 推理模型输出的思考过程
+var reasoningBlock = regexp.MustCompile(`(?is)<(think|thinking|reasoning)>.*?</(think|thinking|reasoning)>`)
+
+// fencedBlock markdown代码块，语言标记可省略
+var fencedBlock = regexp.MustCompile("(?s)```[A-Za-z]*\\s*\\n?(.*?)```")
+
+// StripReasoning 去掉<think>等思考过程；只有结束标签时(部分模型省略开始标签)取最后一个结束标签之后的内容
+func StripReasoning(answer string) string {
+	answer = reasoningBlock.ReplaceAllString(answer, "")
+	lower := strings.ToLower(answer)
+	for _, tag := range []string{"</think>", "</thinking>", "</reasoning>"} {
+		if i := strings.LastIndex(lower, tag); i >= 0 {
+			answer, lower = answer[i+len(tag):], lower[i+len(tag):]
+		}
+	}
+	// 未闭合的思考过程(输出被截断)没有可用结果
+	for _, tag := range []string{"<think>", "<thinking>", "<reasoning>"} {
+		if i := strings.Index(lower, tag); i >= 0 {
+			answer = answer[:i]
+			lower = lower[:i]
+		}
+	}
+	return strings.TrimSpace(answer)
+}
+
+// ExtractJSON 从回答中取出JSON文本：优先取```json代码块，其次为第一个括号配对完整的对象或数组；
+// 整个回答是JSON字符串字面量(被多包了一层引号)时先解开
+func ExtractJSON(answer string) (string, error) {
+	return extractJSON(answer, 0)
+}
+
+// extractJSON want为'{'或'['时优先取该类型，避免取到正文中的"[1]"之类或数组中的单个元素
+func extractJSON(answer string, want rune) (string, error) {
+	s := StripReasoning(answer)
+	if len(s) > 1 && s[0] == '"' {
+		var inner string
+		if json.Unmarshal([]byte(s), &inner) == nil {
+			s = strings.TrimSpace(inner)
+		}
+	}
+	for _, m := range fencedBlock.FindAllStringSubmatch(s, -1) {
+		if block, ok := balancedJSON(m[1], want); ok {
+			return block, nil
+		}
+	}
+	if block, ok := balancedJSON(s, want); ok {
+		return block, nil
+	}
+	return "", &LLMParseError{Kind: LLMErrNoJSON, Message: "回答中没有JSON对象或数组"}
+}
+
+// balancedJSON 在括号配对完整的对象或数组中依次选择：want类型且修复后可解析的、want类型的、
+// 其他可解析的、其他的。want为'['时元素不是对象的数组(如正文中的"[1]")视为其他类型。
+// 字符串内的括号不计入，选中不能解析的由调用方报告语法错误
+func balancedJSON(s string, want rune) (string, bool) {
+	runes := []rune(s)
+	var candidates [4]string
+	for start := 0; start < len(runes); start++ {
+		open := jsonOpen(runes[start])
+		if open == 0 {
+			continue
+		}
+		end := matchBracket(runes, start)
+		if end < 0 {
+			continue
+		}
+		candidate := string(runes[start : end+1])
+		rank := 0
+		if want != 0 && (open != want || (want == '[' && !objectArray(runes[start+1:end]))) {
+			rank = 2
+		}
+		if !json.Valid([]byte(candidate)) && !json.Valid([]byte(repairJSON(candidate))) {
+			rank++
+		}
+		if candidates[rank] == "" {
+			candidates[rank] = candidate
+		}
+		if rank == 0 {
+			break
+		}
+	}
+	for _, c := range candidates {
+		if c != "" {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// objectArray 数组内容为空或以对象开头
+func objectArray(inner []rune) bool {
+	for _, r := range inner {
+		if !strings.ContainsRune(" \t\r\n", r) {
+			return jsonOpen(r) == '{'
+		}
+	}
+	return true
+}
+
+func jsonOpen(r rune) rune {
+	switch r {
+	case '{', '｛':
+		return '{'
+	case '[', '［':
+		return '['
+	}
+	return 0
+}
+
+func jsonClose(r rune) rune {
+	switch r {
+	case '}', '｝':
+		return '}'
+	case ']', '］':
+		return ']'
+	}
+	return 0
+}
+
+// matchBracket 返回与start处括号配对的位置，不配对时返回-1。字符串可以用"、'或“”包围
+func matchBracket(runes []rune, start int) int {
+	var stack []rune
+	var quote rune
+	for i := start; i < len(runes); i++ {
+		r := runes[i]
+		if quote != 0 {
+			switch {
+			case r == '\\':
+				i++
+			case r == quote || (quote == '”' && r == '"'):
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '“':
+			quote = '”'
+		case jsonOpen(r) != 0:
+			stack = append(stack, jsonOpen(r))
+		case jsonClose(r) != 0:
+			want := '{'
+			if jsonClose(r) == ']' {
+				want = '['
+			}
+			if len(stack) == 0 || stack[len(stack)-1] != want {
+				return -1
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// repairJSON 修复模型常见的JSON错误：单引号或中文引号字符串、全角标点、末尾多余的逗号、字符串中的换行
+func repairJSON(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	var quote rune
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote != 0 {
+			switch {
+			case r == '\\' && i+1 < len(runes):
+				i++
+				if quote == '\'' && runes[i] == '\'' {
+					b.WriteRune('\'')
+				} else {
+					b.WriteRune(r)
+					b.WriteRune(runes[i])
+				}
+			case r == quote || (quote == '”' && r == '"'):
+				quote = 0
+				b.WriteByte('"')
+			case r == '"':
+				b.WriteString(`\"`)
+			case r == '\n':
+				b.WriteString(`\n`)
+			case r == '\r':
+			case r == '\t':
+				b.WriteString(`\t`)
+			default:
+				b.WriteRune(r)
+			}
+			continue
+		}
+		switch r {
+		case '"', '\'':
+			quote = r
+			b.WriteByte('"')
+		case '“':
+			quote = '”'
+			b.WriteByte('"')
+		case '，', ',':
+			// 后面只有空白就是对象或数组末尾的逗号
+			j := i + 1
+			for j < len(runes) && strings.ContainsRune(" \t\r\n", runes[j]) {
+				j++
+			}
+			if j < len(runes) && jsonClose(runes[j]) != 0 {
+				continue
+			}
+			b.WriteByte(',')
+		case '：':
+			b.WriteByte(':')
+		case '｛', '｝', '［', '］':
+			if c := jsonOpen(r); c != 0 {
+				b.WriteRune(c)
+			} else {
+				b.WriteRune(jsonClose(r))
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ParseLLMJSON 从模型回答中提取JSON，按schema校验后解码到out。schema为nil时不校验；
+// 声明为数值的字段接受数字字符串，声明为字符串的字段接受数字(保留原文)与布尔值
+func ParseLLMJSON(answer string, schema *LLMSchema, out any) error {
+	var want rune
+	if schema != nil {
+		switch schema.Type {
+		case "object":
+			want = '{'
+		case "array":
+			want = '['
+		}
+	}
+	text, err := extractJSON(answer, want)
+	if err != nil {
+		return err
+	}
+	v, err := decodeLLMJSON(text)
+	if err != nil {
+		var err2 error
+		if v, err2 = decodeLLMJSON(repairJSON(text)); err2 != nil {
+			return &LLMParseError{Kind: LLMErrSyntax, Message: err.Error()}
+		}
+	}
+	if schema != nil {
+		if v, err = schema.check(v); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return &LLMParseError{Kind: LLMErrSyntax, Message: err.Error()}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return &LLMParseError{Kind: LLMErrSchema, Message: err.Error()}
+	}
+	return nil
+}
+
+// decodeLLMJSON 解码JSON，数字保留为json.Number，避免超过2^53的整数被舍入、"1.10"丢掉末尾的0
+func decodeLLMJSON(text string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("JSON之后还有多余的内容")
+	}
+	return v, nil
+}
+
+// check 校验并转换回答，返回转换后的值
+func (s *LLMSchema) check(v any) (any, error) {
+	switch s.Type {
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			// 只有一个元素时模型可能省略数组
+			obj, isObj := v.(map[string]any)
+			if !isObj {
+				return nil, &LLMParseError{Kind: LLMErrSchema, Message: "应为数组"}
+			}
+			items = []any{obj}
+		}
+		for i, item := range items {
+			obj, ok := item.(map[string]any)
+			if !ok {
+				return nil, &LLMParseError{Kind: LLMErrSchema, Path: fmt.Sprintf("[%d]", i), Message: "应为对象"}
+			}
+			if err := checkLLMFields(obj, s.Fields, fmt.Sprintf("[%d].", i)); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, &LLMParseError{Kind: LLMErrSchema, Message: "应为对象"}
+		}
+		return obj, checkLLMFields(obj, s.Fields, "")
+	default:
+		return v, nil
+	}
+}
+
+// checkLLMFields 检查必填字段与类型，可转换的值原地替换
+func checkLLMFields(obj map[string]any, fields []LLMField, prefix string) error {
+	for _, f := range fields {
+		val, ok := obj[f.Name]
+		if !ok || val == nil {
+			if f.Required {
+				return &LLMParseError{Kind: LLMErrSchema, Path: prefix + f.Name, Message: "缺少必填字段"}
+			}
+			continue
+		}
+		converted, ok := coerceLLMValue(val, f.Type)
+		if !ok {
+			return &LLMParseError{Kind: LLMErrSchema, Path: prefix + f.Name, Message: fmt.Sprintf("应为%s: %v", f.Type, val)}
+		}
+		obj[f.Name] = converted
+	}
+	return nil
+}
+
+func coerceLLMValue(v any, typ string) (any, bool) {
+	switch typ {
+	case "string":
+		switch x := v.(type) {
+		case string:
+			return x, true
+		case json.Number:
+			return x.String(), true
+		case bool:
+			return strconv.FormatBool(x), true
+		}
+		return nil, false
+	case "integer", "number":
+		var n json.Number
+		switch x := v.(type) {
+		case json.Number:
+			n = x
+		case string:
+			n = json.Number(strings.TrimSpace(x))
+		default:
+			return nil, false
+		}
+		f, err := n.Float64()
+		if err != nil || !json.Valid([]byte(n)) {
+			return nil, false
+		}
+		if typ == "integer" {
+			if i, err := n.Int64(); err == nil {
+				return json.Number(strconv.FormatInt(i, 10)), true
+			}
+			// 13.0之类的写法
+			if f != math.Trunc(f) || math.Abs(f) >= 1<<63 {
+				return nil, false
+			}
+			return json.Number(strconv.FormatInt(int64(f), 10)), true
+		}
+		return n, true
+	case "boolean":
+		switch x := v.(type) {
+		case bool:
+			return x, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(x))
+			return b, err == nil
+		}
+		return nil, false
+	case "array":
+		_, ok := v.([]any)
+		return v, ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return v, ok
+	}
+	return v, true
+}
+
+// llmOutputText 工作流输出变量转为文本，模型节点的输出通常是字符串，代码节点可能直接输出对象
+func llmOutputText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
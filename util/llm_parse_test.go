@@ -0,0 +1,232 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// thinkAnswer 推理模型的回答，思考过程中有大量数字和括号(参考sh/dify.sh中的返回)
+const thinkAnswer = "<think>\n好的，让我来想一下这个问题。用户给了一个关于病理信息的判断任务，要求根据提供的患者信息判断属于哪个访视阶段，" +
+	"最后输出一个数字（1-6）。末次月经2024-03-01，B超提示孕13周+2天，[1]号检查单显示{\"hcg\": 12000}。\n" +
+	"按照第2次访视(孕13-27周)的规则判断。\n</think>\n\n{\"visit_number\": 2, \"gestational_weeks\": 13}"
+
+// idSchema 含长整数ID的结构
+var idSchema = &LLMSchema{Type: "object", Fields: []LLMField{
+	{Name: "person_id", Type: "integer", Required: true},
+	{Name: "visit_id", Type: "integer"},
+}}
+
+func TestParseLLMJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		schema *LLMSchema
+		// want 解析成功时期望的结果(JSON)
+		want     string
+		wantKind string
+		wantPath string
+	}{
+		{
+			name:   "think中含数字和JSON",
+			answer: thinkAnswer,
+			schema: visitResultSchema,
+			want:   `{"visit_number": 2, "gestational_weeks": 13}`,
+		},
+		{
+			name:   "只有结束标签的think",
+			answer: "判断依据：孕周[13]周，第{2}次访视\n</think>\n{\"visit_number\": 2, \"gestational_weeks\": 13}",
+			schema: visitResultSchema,
+			want:   `{"visit_number": 2, "gestational_weeks": 13}`,
+		},
+		{
+			name:     "被截断的think",
+			answer:   "<think>\n孕13周，应为第2次访视，输出{\"visit_number\": 2",
+			schema:   visitResultSchema,
+			wantKind: LLMErrNoJSON,
+		},
+		{
+			name:   "json代码块",
+			answer: "结果如下：\n```json\n[{\"code\": \"HGB\", \"name\": \"血红蛋白\", \"value\": 125}]\n```\n以上仅供参考。",
+			schema: indicatorSchema,
+			want:   `[{"code": "HGB", "name": "血红蛋白", "value": "125"}]`,
+		},
+		{
+			name:   "正文中的[1]在数组之前",
+			answer: "根据检查单[1]，结果为：[{\"code\": \"HBsAg\", \"value\": \"阴性\"}]",
+			schema: indicatorSchema,
+			want:   `[{"code": "HBsAg", "value": "阴性"}]`,
+		},
+		{
+			name:   "末尾多余的逗号",
+			answer: "[{\"code\": \"HGB\", \"value\": \"125\",}, {\"code\": \"PLT\", \"value\": \"210\",},]",
+			schema: indicatorSchema,
+			want:   `[{"code": "HGB", "value": "125"}, {"code": "PLT", "value": "210"}]`,
+		},
+		{
+			name:   "单引号",
+			answer: `[{'code': 'GLU', 'value_explain': 'it\'s "normal"'}]`,
+			schema: indicatorSchema,
+			want:   `[{"code": "GLU", "value_explain": "it's \"normal\""}]`,
+		},
+		{
+			name:   "全角标点",
+			answer: "｛\"visit_number\"：3，\"gestational_weeks\"：\"30\"｝",
+			schema: visitResultSchema,
+			want:   `{"visit_number": 3, "gestational_weeks": 30}`,
+		},
+		{
+			name:   "全角括号的数组",
+			answer: "［｛\"code\"：\"HGB\"，\"value\"：\"125\"｝］",
+			schema: indicatorSchema,
+			want:   `[{"code": "HGB", "value": "125"}]`,
+		},
+		{
+			name:   "被引号包住的JSON字符串",
+			answer: `"{\"visit_number\": 1, \"gestational_weeks\": 8}"`,
+			schema: visitResultSchema,
+			want:   `{"visit_number": 1, "gestational_weeks": 8}`,
+		},
+		{
+			name:   "数组只有一个元素时省略了数组",
+			answer: `{"code": "HGB", "value": 125.5}`,
+			schema: indicatorSchema,
+			want:   `[{"code": "HGB", "value": "125.5"}]`,
+		},
+		{
+			name:   "超过2^53的整数",
+			answer: `{"person_id": 9007199254740993, "visit_id": "1234567890123456789"}`,
+			schema: idSchema,
+			want:   `{"person_id": 9007199254740993, "visit_id": 1234567890123456789}`,
+		},
+		{
+			name:   "数字code保留末尾的0",
+			answer: `[{"code": 1.10, "value": 3.50}]`,
+			schema: indicatorSchema,
+			want:   `[{"code": "1.10", "value": "3.50"}]`,
+		},
+		{
+			name:   "整数字段写成13.0",
+			answer: `{"visit_number": 2.0, "gestational_weeks": "13.0"}`,
+			schema: visitResultSchema,
+			want:   `{"visit_number": 2, "gestational_weeks": 13}`,
+		},
+		{
+			name:     "没有JSON",
+			answer:   "<think>资料不足</think>无法判断访视次数",
+			schema:   visitResultSchema,
+			wantKind: LLMErrNoJSON,
+		},
+		{
+			name:     "缺少逗号无法修复",
+			answer:   `{"visit_number": 2 "gestational_weeks": 13}`,
+			schema:   visitResultSchema,
+			wantKind: LLMErrSyntax,
+		},
+		{
+			name:     "缺少必填字段",
+			answer:   `{"gestational_weeks": 13}`,
+			schema:   visitResultSchema,
+			wantKind: LLMErrSchema,
+			wantPath: "visit_number",
+		},
+		{
+			name:     "整数字段为小数",
+			answer:   `{"visit_number": 2, "gestational_weeks": "13.5"}`,
+			schema:   visitResultSchema,
+			wantKind: LLMErrSchema,
+			wantPath: "gestational_weeks",
+		},
+		{
+			name:     "整数字段为文字",
+			answer:   `{"visit_number": "第二次", "gestational_weeks": 13}`,
+			schema:   visitResultSchema,
+			wantKind: LLMErrSchema,
+			wantPath: "visit_number",
+		},
+		{
+			name:     "第4个元素缺少code",
+			answer:   `[{"code": "A"}, {"code": "B"}, {"code": "C"}, {"name": "血糖", "value": "5.1"}]`,
+			schema:   indicatorSchema,
+			wantKind: LLMErrSchema,
+			wantPath: "[3].code",
+		},
+		{
+			name:     "数组元素不是对象",
+			answer:   `["HGB", "PLT"]`,
+			schema:   indicatorSchema,
+			wantKind: LLMErrSchema,
+			wantPath: "[0]",
+		},
+		{
+			name:     "字符串字段为对象",
+			answer:   `[{"code": "HGB", "value": {"num": 125}}]`,
+			schema:   indicatorSchema,
+			wantKind: LLMErrSchema,
+			wantPath: "[0].value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 解码为RawMessage再按json.Number比较，数字的原文不同也算不一致
+			var raw json.RawMessage
+			err := ParseLLMJSON(tt.answer, tt.schema, &raw)
+			if tt.wantKind == "" {
+				if err != nil {
+					t.Fatalf("ParseLLMJSON() error = %v", err)
+				}
+				got, err := decodeLLMJSON(string(raw))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := decodeLLMJSON(tt.want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("ParseLLMJSON() = %s, want %s", raw, tt.want)
+				}
+				return
+			}
+			var pe *LLMParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("ParseLLMJSON() error = %v, want LLMParseError", err)
+			}
+			if pe.Kind != tt.wantKind || pe.Path != tt.wantPath {
+				t.Errorf("ParseLLMJSON() error Kind/Path = %q/%q, want %q/%q", pe.Kind, pe.Path, tt.wantKind, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestParseLLMJSONInt64(t *testing.T) {
+	var out struct {
+		PersonID int64 `json:"person_id"`
+		VisitID  int64 `json:"visit_id"`
+	}
+	if err := ParseLLMJSON(`{"person_id": 9007199254740993, "visit_id": "9223372036854775807"}`, idSchema, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.PersonID != 9007199254740993 || out.VisitID != 9223372036854775807 {
+		t.Errorf("ParseLLMJSON() = %+v", out)
+	}
+}
+
+func TestStripReasoning(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{thinkAnswer, `{"visit_number": 2, "gestational_weeks": 13}`},
+		{"<THINKING>先分析</THINKING>\n结论：2", "结论：2"},
+		{"孕13周\n</think>\n结论：2", "结论：2"},
+		{"结论：2\n<think>补充说明被截断", "结论：2"},
+		{"没有思考过程", "没有思考过程"},
+	}
+	for _, tt := range tests {
+		if got := StripReasoning(tt.answer); got != tt.want {
+			t.Errorf("StripReasoning(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+}
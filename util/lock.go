@@ -0,0 +1,284 @@
+package util
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/spf13/viper"
+)
+
+// ErrLockHeld 锁已被其他实例持有
+var ErrLockHeld = errors.New("锁已被其他实例持有")
+
+// Locker 分布式锁，多实例部署时保证定时任务只在一个实例上执行
+type Locker interface {
+	// TryLock 非阻塞获取锁，被占用时返回ErrLockHeld
+	TryLock(ctx context.Context, name string) (Lock, error)
+}
+
+// Lock 已持有的锁。基于数据库会话实现，进程崩溃后连接断开即自动释放
+type Lock interface {
+	Release() error
+	// Lost 底层会话失效时关闭，持有者应停止工作
+	Lost() <-chan struct{}
+}
+
+const (
+	lockKeepAlive     = 15 * time.Second // 锁连接的探活间隔
+	lockRetryInterval = 10 * time.Second // queue策略下等待锁的重试间隔
+	leaderLockName    = "scheduler-leader"
+)
+
+// InitLocker 按LOCK.DRIVER创建分布式锁，并启动调度器的主节点选举
+func InitLocker() error {
+	prefix := viper.GetString("LOCK.PREFIX")
+	var locker Locker
+	switch driver := viper.GetString("LOCK.DRIVER"); driver {
+	case "", "none":
+		locker = localLocker{}
+	case "postgres":
+		db, err := openLockDB(DBPgStruct)
+		if err != nil {
+			return err
+		}
+		locker = &pgLocker{db: db, prefix: prefix}
+	case "mysql":
+		if cfg, _ := DBs.Config(DBDolphinScheduler); cfg.Driver != "mysql" {
+			return fmt.Errorf("LOCK.DRIVER为mysql时DB.DRIVER必须为mysql")
+		}
+		db, err := openLockDB(DBDolphinScheduler)
+		if err != nil {
+			return err
+		}
+		locker = &mysqlLocker{db: db, prefix: prefix}
+	default:
+		return fmt.Errorf("不支持的LOCK.DRIVER: %s", driver)
+	}
+
+	Jobs.SetLocker(locker)
+	Leader = &LeaderElector{locker: locker}
+	go Leader.run()
+	slog.Info("分布式锁已初始化", "driver", viper.GetString("LOCK.DRIVER"))
+	return nil
+}
+
+// openLockDB 按命名连接的配置为锁单独打开连接池。每个持有的锁独占一个连接直到释放，主节点锁常驻，
+// 共用业务连接池时会占用任务自身查询的连接；同时持有的锁不超过任务类型数+1
+func openLockDB(name string) (*sql.DB, error) {
+	cfg, err := DBs.Config(name)
+	if err != nil {
+		return nil, fmt.Errorf("锁数据库不可用: %w", err)
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("打开锁数据库%s失败: %w", name, err)
+	}
+	db.SetMaxOpenConns(viper.GetInt("LOCK.MAX_OPEN_CONNS"))
+	db.SetMaxIdleConns(1)
+	if err := prometheus.Register(collectors.NewDBStatsCollector(db, name+"_lock")); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("注册锁连接池指标失败: %w", err)
+	}
+	return db, nil
+}
+
+// LeaderElector 持有scheduler-leader锁的实例为主节点，只有主节点触发定时任务
+type LeaderElector struct {
+	locker Locker
+	mu     sync.RWMutex
+	leader bool
+}
+
+// Leader 全局主节点选举，InitLocker前为nil（视为单实例）
+var Leader *LeaderElector
+
+// IsLeader 当前实例是否为主节点
+func (l *LeaderElector) IsLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.leader
+}
+
+func (l *LeaderElector) setLeader(v bool) {
+	l.mu.Lock()
+	l.leader = v
+	l.mu.Unlock()
+	if v {
+		schedulerLeader.Set(1)
+	} else {
+		schedulerLeader.Set(0)
+	}
+}
+
+func (l *LeaderElector) run() {
+	retry := viper.GetDuration("LOCK.LEADER_RETRY")
+	if retry <= 0 {
+		retry = lockRetryInterval
+	}
+	for {
+		lock, err := l.locker.TryLock(context.Background(), leaderLockName)
+		if err != nil {
+			if !errors.Is(err, ErrLockHeld) {
+				slog.Error("主节点选举失败", "error", err)
+			}
+			time.Sleep(retry)
+			continue
+		}
+
+		slog.Info("当前实例成为定时任务主节点")
+		l.setLeader(true)
+		<-lock.Lost()
+		l.setLeader(false)
+		lock.Release()
+		slog.Warn("当前实例失去定时任务主节点身份")
+	}
+}
+
+// localLocker 单实例部署时使用，总是获取成功
+type localLocker struct{}
+
+func (localLocker) TryLock(ctx context.Context, name string) (Lock, error) {
+	return &dbLock{name: name, lost: make(chan struct{}), stop: make(chan struct{})}, nil
+}
+
+// pgLocker 基于Postgres会话级advisory lock
+type pgLocker struct {
+	db     *sql.DB
+	prefix string
+}
+
+func (p *pgLocker) TryLock(ctx context.Context, name string) (Lock, error) {
+	fullName := p.prefix + ":" + name
+	h := fnv.New64a()
+	h.Write([]byte(fullName))
+	key := int64(h.Sum64())
+
+	return acquireDBLock(ctx, p.db, fullName,
+		func(conn *sql.Conn) (bool, error) {
+			var ok bool
+			err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok)
+			return ok, err
+		},
+		func(conn *sql.Conn) error {
+			_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+			return err
+		})
+}
+
+// mysqlLocker 基于MySQL GET_LOCK，锁名最长64字符，超长时保留前缀并以sha1区分
+type mysqlLocker struct {
+	db     *sql.DB
+	prefix string
+}
+
+func (m *mysqlLocker) TryLock(ctx context.Context, name string) (Lock, error) {
+	fullName := m.prefix + ":" + name
+	if len(fullName) > 64 {
+		// 截断会使前64字符相同的锁名冲突，保留23字符前缀便于识别
+		sum := sha1.Sum([]byte(fullName))
+		fullName = strings.ToValidUTF8(fullName[:23], "") + ":" + hex.EncodeToString(sum[:])
+	}
+
+	return acquireDBLock(ctx, m.db, fullName,
+		func(conn *sql.Conn) (bool, error) {
+			var ok sql.NullInt64
+			err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", fullName).Scan(&ok)
+			return ok.Valid && ok.Int64 == 1, err
+		},
+		func(conn *sql.Conn) error {
+			_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", fullName)
+			return err
+		})
+}
+
+// acquireDBLock 在独占连接上加锁，锁随连接存活并定期探活
+func acquireDBLock(ctx context.Context, db *sql.DB, name string,
+	try func(*sql.Conn) (bool, error), release func(*sql.Conn) error) (Lock, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		lockAcquireTotal.WithLabelValues(name, "error").Inc()
+		return nil, fmt.Errorf("获取锁连接失败: %w", err)
+	}
+	ok, err := try(conn)
+	if err != nil {
+		conn.Close()
+		lockAcquireTotal.WithLabelValues(name, "error").Inc()
+		return nil, fmt.Errorf("获取锁%s失败: %w", name, err)
+	}
+	if !ok {
+		conn.Close()
+		lockAcquireTotal.WithLabelValues(name, "held").Inc()
+		return nil, ErrLockHeld
+	}
+
+	lockAcquireTotal.WithLabelValues(name, "acquired").Inc()
+	lockHeld.WithLabelValues(name).Set(1)
+	l := &dbLock{name: name, conn: conn, release: release, lost: make(chan struct{}), stop: make(chan struct{})}
+	go l.keepAlive()
+	return l, nil
+}
+
+// dbLock 持有锁的数据库连接
+type dbLock struct {
+	name     string
+	conn     *sql.Conn
+	release  func(*sql.Conn) error
+	lost     chan struct{}
+	stop     chan struct{}
+	once     sync.Once
+	lostOnce sync.Once
+}
+
+func (l *dbLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *dbLock) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		if l.conn == nil {
+			return
+		}
+		lockHeld.WithLabelValues(l.name).Set(0)
+		err = l.release(l.conn)
+		// 关闭连接，即使解锁失败会话结束后锁也会释放
+		l.conn.Close()
+	})
+	return err
+}
+
+func (l *dbLock) keepAlive() {
+	ticker := time.NewTicker(lockKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := l.conn.PingContext(ctx)
+			cancel()
+			if err != nil {
+				slog.Error("锁的数据库连接失效", "lock", l.name, "error", err)
+				lockHeld.WithLabelValues(l.name).Set(0)
+				l.lostOnce.Do(func() { close(l.lost) })
+				return
+			}
+		}
+	}
+}
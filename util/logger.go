@@ -0,0 +1,93 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDCtxKey struct{}
+
+// WithRequestID 在ctx中记录请求ID，日志与后台任务会自动带上
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, id)
+}
+
+// RequestIDFromContext 读取ctx中的请求ID
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return id
+}
+
+// NewRequestID 生成请求ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// InitLogger 按LOG.LEVEL/LOG.FORMAT/LOG.OUTPUT初始化slog，标准库log的输出也转到slog
+func InitLogger() error {
+	out := os.Stdout
+	if strings.ToLower(viper.GetString("LOG.OUTPUT")) == "stderr" {
+		out = os.Stderr
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(viper.GetString("LOG.LEVEL"))); err != nil {
+		return fmt.Errorf("LOG.LEVEL无效: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format := strings.ToLower(viper.GetString("LOG.FORMAT")); format {
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	case "text", "":
+		h = slog.NewTextHandler(out, opts)
+	default:
+		return fmt.Errorf("LOG.FORMAT无效: %s", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+// contextHandler 从ctx中提取request_id、job_id、job_type、trace_id写入每条日志
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if job := JobFromContext(ctx); job != nil {
+		r.AddAttrs(slog.String("job_id", job.ID), slog.String("job_type", job.Type))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Redact 患者姓名、文档内容等敏感文本默认不写入日志，LOG.SENSITIVE=true时原样输出
+func Redact(s string) string {
+	if viper.GetBool("LOG.SENSITIVE") {
+		return s
+	}
+	return fmt.Sprintf("[redacted len=%d]", len(s))
+}
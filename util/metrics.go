@@ -0,0 +1,283 @@
+package util
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// lockHeld 当前实例是否持有某个分布式锁
+	lockHeld = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_lock_held",
+			Help: "Whether this instance currently holds the distributed lock (1) or not (0).",
+		},
+		[]string{"lock"},
+	)
+	// lockAcquireTotal 获取分布式锁的次数，result为acquired/held/error
+	lockAcquireTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_lock_acquire_total",
+			Help: "Total number of distributed lock acquisition attempts.",
+		},
+		[]string{"lock", "result"},
+	)
+	// schedulerLeader 当前实例是否为定时任务主节点
+	schedulerLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_scheduler_leader",
+			Help: "Whether this instance is the scheduler leader (1) or not (0).",
+		},
+	)
+
+	// dbUp 命名数据库连接最近一次探活结果，连接池统计见go_sql_*指标
+	dbUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_db_up",
+			Help: "Whether the last ping of a named database connection succeeded (1) or not (0).",
+		},
+		[]string{"db"},
+	)
+
+	// smsTotal 短信发送结果，channel为soap(真实发送)/log(仅记录日志)
+	smsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_sms_total",
+			Help: "Total number of SMS notifications by channel, recipient group and result.",
+		},
+		[]string{"channel", "group", "result"},
+	)
+	// smsGatewayDuration 短信网关请求耗时
+	smsGatewayDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_sms_gateway_duration_seconds",
+			Help:    "Latency of SMS gateway requests.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+
+	// difyRequestDuration 单次Dify调用耗时，app为chat/visit_workflow/mz_workflow
+	difyRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_dify_request_duration_seconds",
+			Help:    "Latency of individual Dify API calls.",
+			Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+		},
+		[]string{"app", "result"},
+	)
+	// difyRetriesTotal Dify调用重试次数
+	difyRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_dify_retries_total",
+			Help: "Total number of Dify API call retries.",
+		},
+		[]string{"app"},
+	)
+	// difyFailuresTotal 重试耗尽或结果无效的Dify调用
+	difyFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_dify_failures_total",
+			Help: "Total number of Dify calls that failed after all retries or returned an unusable result.",
+		},
+		[]string{"app", "reason"},
+	)
+	// difyNodeDuration streaming模式下工作流各节点耗时，来自node_finished事件
+	difyNodeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_dify_node_duration_seconds",
+			Help:    "Elapsed time of Dify workflow nodes reported by streaming runs.",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+		},
+		[]string{"app", "node_type", "status"},
+	)
+	// difyTokensTotal Dify调用消耗的token数，type为prompt/completion/total，job为触发调用的任务类型
+	difyTokensTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_dify_tokens_total",
+			Help: "Tokens consumed by successful Dify calls.",
+		},
+		[]string{"app", "job", "type"},
+	)
+	// difyCostTotal Dify返回的调用费用
+	difyCostTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_dify_cost_total",
+			Help: "Price reported by Dify for successful calls.",
+		},
+		[]string{"app", "currency"},
+	)
+	// mzIndicatorDiscrepanciesTotal 门诊指标结果与请求不一致的指标数
+	mzIndicatorDiscrepanciesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_mz_indicator_discrepancies_total",
+			Help: "Total number of outpatient indicator results that did not match the request, by kind.",
+		},
+		[]string{"kind"},
+	)
+	// difyCircuitOpen Dify应用是否处于熔断状态
+	difyCircuitOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_dify_circuit_open",
+			Help: "Whether calls to a Dify app are currently blocked by the circuit breaker.",
+		},
+		[]string{"app"},
+	)
+
+	// pipelineRecordsTotal ProcessVisits/ProcessMZ处理的记录数，result为processed/updated/failed等
+	pipelineRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_pipeline_records_total",
+			Help: "Total number of records handled by the data pipelines.",
+		},
+		[]string{"job", "result"},
+	)
+	// invalidRecordsTotal ValidatePatientData发现的无效记录
+	invalidRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_invalid_records_total",
+			Help: "Total number of invalid t_patient_data records found, by value_type.",
+		},
+		[]string{"value_type"},
+	)
+	// delMysqlRowsDeleted DelMysql删除的重复任务定义行
+	delMysqlRowsDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_del_mysql_rows_deleted_total",
+			Help: "Total number of duplicate t_ds_task_definition_log rows deleted.",
+		},
+	)
+	// syncTableDuration 单表同步耗时
+	syncTableDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_sync_table_duration_seconds",
+			Help: "Duration of the last MySQL to Postgres sync of each table.",
+		},
+		[]string{"table"},
+	)
+	// syncTableSuccess 单表最近一次同步是否成功
+	syncTableSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_sync_table_success",
+			Help: "Whether the last MySQL to Postgres sync of each table succeeded (1) or not (0).",
+		},
+		[]string{"table"},
+	)
+	// syncTablesTotal 同步表数
+	syncTablesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_sync_tables_total",
+			Help: "Total number of table syncs by result.",
+		},
+		[]string{"result"},
+	)
+	// syncVerifyRowDiff 最近一次校验的源表与目标表行数差
+	syncVerifyRowDiff = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_sync_verify_row_diff",
+			Help: "Source minus target row count found by the last verification of each table.",
+		},
+		[]string{"table"},
+	)
+	// syncVerifyMismatchChunks 最近一次校验不一致的分块数
+	syncVerifyMismatchChunks = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_sync_verify_mismatched_chunks",
+			Help: "Number of primary key chunks that differed in the last verification of each table.",
+		},
+		[]string{"table"},
+	)
+	// syncVerifyTablesTotal 校验表数
+	syncVerifyTablesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_sync_verify_tables_total",
+			Help: "Total number of table verifications by result (match, mismatch, repaired, error).",
+		},
+		[]string{"result"},
+	)
+
+	// jobRunsTotal 任务执行次数
+	jobRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_job_runs_total",
+			Help: "Total number of job runs by job type, trigger and final status.",
+		},
+		[]string{"job", "trigger", "status"},
+	)
+	// jobDuration 任务执行耗时
+	jobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_job_duration_seconds",
+			Help:    "Duration of job runs.",
+			Buckets: []float64{1, 10, 30, 60, 300, 900, 1800, 3600, 7200, 14400},
+		},
+		[]string{"job"},
+	)
+	// jobLastSuccess 任务最近一次成功结束的时间
+	jobLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_job_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful run of each job.",
+		},
+		[]string{"job"},
+	)
+	// jobLastRunCounter 任务最近一次执行的计数器，如ProcessVisits的updated
+	jobLastRunCounter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_job_last_run_count",
+			Help: "Counters reported by the last finished run of each job.",
+		},
+		[]string{"job", "counter"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		lockHeld, lockAcquireTotal, schedulerLeader, dbUp,
+		smsTotal, smsGatewayDuration,
+		difyRequestDuration, difyRetriesTotal, difyFailuresTotal, difyNodeDuration, difyCircuitOpen,
+		difyTokensTotal, difyCostTotal, mzIndicatorDiscrepanciesTotal,
+		pipelineRecordsTotal, invalidRecordsTotal, delMysqlRowsDeleted,
+		syncTableDuration, syncTableSuccess, syncTablesTotal,
+		syncVerifyRowDiff, syncVerifyMismatchChunks, syncVerifyTablesTotal,
+		jobRunsTotal, jobDuration, jobLastSuccess, jobLastRunCounter,
+	)
+	Jobs.AddListener(jobMetrics{})
+}
+
+// jobMetrics 任务结束时更新任务相关指标
+type jobMetrics struct{}
+
+func (jobMetrics) JobStarted(v JobView) {}
+
+func (jobMetrics) JobFinished(v JobView) {
+	jobRunsTotal.WithLabelValues(v.Type, v.Trigger, string(v.Status)).Inc()
+	if v.StartedAt != nil && v.FinishedAt != nil {
+		jobDuration.WithLabelValues(v.Type).Observe(v.FinishedAt.Sub(*v.StartedAt).Seconds())
+	}
+	if v.Status == JobSucceeded && v.FinishedAt != nil {
+		jobLastSuccess.WithLabelValues(v.Type).Set(float64(v.FinishedAt.Unix()))
+	}
+	for name, n := range v.Counters {
+		jobLastRunCounter.WithLabelValues(v.Type, name).Set(float64(n))
+	}
+}
+
+// observeDify 记录一次Dify调用的耗时与重试
+func observeDify(app string, attempt int, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	difyRequestDuration.WithLabelValues(app, result).Observe(time.Since(start).Seconds())
+	if attempt > 0 {
+		difyRetriesTotal.WithLabelValues(app).Inc()
+	}
+}
+
+// countPipeline 同时累加任务计数器与流水线指标
+func countPipeline(job *Job, jobType, result string, n int64) {
+	job.Add(result, n)
+	pipelineRecordsTotal.WithLabelValues(jobType, result).Add(float64(n))
+}
@@ -1,132 +1,517 @@
-package util
-
-import (
-    "database/sql"
-    "fmt"
-    "time"
-
-    _ "github.com/go-sql-driver/mysql"
-    "github.com/spf13/viper"
-)
-
-// 定义查询语句常量
-const (
-    outerQuery = `
-        select * from (select code,version,count(1) cnt from t_ds_task_definition_log group by code,version ) t where t.cnt>1 
-    `
-    innerQuery = `
-        select id, code, version, name, create_time as createTime from t_ds_task_definition_log where code =? and version =? order by create_time desc 
-    `
-    deleteQuery = "DELETE FROM t_ds_task_definition_log WHERE id=?"
-)
-
-// DelMysql 执行从数据库中查询特定条件数据并根据规则删除部分数据的操作，基于事务保证数据一致性
-func DelMysql() error {
-    // 延迟处理 panic
-    defer func() {
-        if r := recover(); r != nil {
-            fmt.Printf("Recovered from panic in goroutine: %v", r)
-        }
-    }()
-
-    // 验证 Viper 配置是否已正确加载
-    if viper.GetString("DB.HOST") == "" ||
-        viper.GetString("DB.USER") == "" ||
-        viper.GetString("DB.PASSWORD") == "" ||
-        viper.GetString("DB.DATABASE") == "" {
-        fmt.Printf("Viper配置未正确初始化或缺少数据库连接相关配置项")
-        return fmt.Errorf("Viper配置未正确初始化或缺少数据库连接相关配置项")
-    }
-
-    // 从 Viper 中读取数据库连接信息
-    host := viper.GetString("DB.HOST")
-    user := viper.GetString("DB.USER")
-    password := viper.GetString("DB.PASSWORD")
-    database := viper.GetString("DB.DATABASE")
-
-    // 构建数据库连接字符串
-    dataSourceName := fmt.Sprintf("%s:%s@tcp(%s)/%s", user, password, host, database)
-
-    // 建立数据库连接
-    db, err := sql.Open("mysql", dataSourceName)
-    if err != nil {
-        fmt.Printf("建立数据库连接失败: %v", err)
-        return fmt.Errorf("建立数据库连接失败: %w", err)
-    }
-    // 确保函数结束时关闭数据库连接
-    defer db.Close()
-
-    // 配置数据库连接池参数
-    db.SetConnMaxLifetime(1 * time.Minute) // 设置连接的最大生命周期为 30 分钟
-    db.SetMaxIdleConns(0)                  // 设置最大空闲连接数为 10
-    db.SetMaxOpenConns(3)                  // 设置最大打开连接数为 20
-
-    // 检查数据库连接是否成功
-    err = db.Ping()
-    if err != nil {
-        fmt.Printf("检查数据库连接失败: %v", err)
-        return fmt.Errorf("检查数据库连接失败: %w", err)
-    }
-
-    // 执行外部查询
-    rows, err := db.Query(outerQuery)
-    if err != nil {
-        fmt.Printf("查询失败: %v", err)
-        return fmt.Errorf("查询失败: %w", err)
-    }
-    // 确保查询结果集在函数结束时关闭
-    defer rows.Close()
-
-    // 遍历外部查询结果
-    for rows.Next() {
-        var taskID, taskVersion string
-        var taskCnt int
-
-        err := rows.Scan(&taskID, &taskVersion, &taskCnt)
-        if err != nil {
-            fmt.Printf("扫描结果失败: %v", err)
-            return fmt.Errorf("扫描结果失败: %w", err)
-        }
-        fmt.Printf("Task: %s %s %d\n", taskID, taskVersion, taskCnt)
-
-        // 执行内部查询
-        innerRows, err := db.Query(innerQuery, taskID, taskVersion)
-        if err != nil {
-            fmt.Printf("内部查询失败: %v", err)
-            return fmt.Errorf("内部查询失败: %w", err)
-        }
-        // 确保内部查询结果集在函数结束时关闭
-        defer innerRows.Close()
-
-        isFirst := true
-        // 遍历内部查询结果
-        for innerRows.Next() {
-            var id, code, version, name, createTime string
-
-            err := innerRows.Scan(&id, &code, &version, &name, &createTime)
-            if err != nil {
-                fmt.Printf("扫描内部结果失败: %v", err)
-                return fmt.Errorf("扫描内部结果失败: %w", err)
-            }
-
-            if isFirst {
-                isFirst = false
-                fmt.Printf("不删除: Task: %s %s %s %s %s\n", id, code, version, name, createTime)
-                continue
-            }
-
-            // 执行删除操作
-            result, err := db.Exec(deleteQuery, id)
-            if err != nil {
-                fmt.Printf("删除操作失败: %v", err)
-                return fmt.Errorf("删除操作失败: %w", err)
-            }
-
-            rowsAffected, _ := result.RowsAffected()
-            fmt.Printf("准备删除: Task: %s %s %s %s %s\n", id, code, version, name, createTime)
-            fmt.Printf("删除结果: %d\n", rowsAffected)
-        }
-    }
-    fmt.Println("删除完成")
-    return nil
-}
\ No newline at end of file
+package util
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// 定义查询语句常量
+const (
+	outerQuery = `
+        select code, version, count(1) cnt from t_ds_task_definition_log group by code, version having count(1) > 1
+    `
+	// 同一(code,version)按创建时间倒序，保留第一条；创建时间相同时保留id较大的一条
+	innerQuery = `
+        select id, name, create_time from t_ds_task_definition_log where code = ? and version = ? order by create_time desc, id desc
+    `
+	backupTable = "t_ds_task_definition_log_backup"
+	// 备份表与任务定义表结构无关，整行以JSON保存，DolphinScheduler升级后仍可恢复
+	createBackupTable = `
+        create table if not exists ` + backupTable + ` (
+            backup_id    varchar(64) not null,
+            row_id       bigint      not null,
+            code         varchar(64) not null,
+            version      varchar(32) not null,
+            row_data     longtext    not null,
+            backed_up_at datetime    not null,
+            restored_at  datetime    null,
+            primary key (backup_id, row_id)
+        )
+    `
+)
+
+// DelMysql备份方式
+const (
+	DelMysqlBackupNone  = "none"
+	DelMysqlBackupTable = "table" // 写入t_ds_task_definition_log_backup，与删除在同一事务中
+	DelMysqlBackupFile  = "file"  // 写入DEL_MYSQL.BACKUP_DIR下的JSONL文件
+)
+
+// DelMysqlOptions DelMysql执行选项
+type DelMysqlOptions struct {
+	DryRun   bool   // 只生成删除计划，不删除
+	Backup   string // none / table / file
+	BackupID string // 备份批次，默认使用任务ID
+}
+
+// DelMysqlGroup 一组重复的(code,version)及其保留、删除的记录id
+type DelMysqlGroup struct {
+	Code      string  `json:"code"`
+	Version   string  `json:"version"`
+	KeepIDs   []int64 `json:"keep_ids"`
+	DeleteIDs []int64 `json:"delete_ids"`
+}
+
+// DelMysqlReport DelMysql执行结果，dry-run时即为删除计划
+type DelMysqlReport struct {
+	DryRun      bool            `json:"dry_run"`
+	Backup      string          `json:"backup"`
+	BackupID    string          `json:"backup_id,omitempty"`
+	BackupFile  string          `json:"backup_file,omitempty"`
+	Groups      []DelMysqlGroup `json:"groups"`
+	RowsDeleted int64           `json:"rows_deleted"`
+}
+
+// DelMysqlRestoreReport 恢复结果，已存在的记录跳过
+type DelMysqlRestoreReport struct {
+	Source   string `json:"source"`
+	Total    int    `json:"total"`
+	Restored int    `json:"restored"`
+	Skipped  int    `json:"skipped"`
+}
+
+// delMysqlBackupRow 备份的一行任务定义
+type delMysqlBackupRow struct {
+	BackupID   string         `json:"backup_id"`
+	ID         int64          `json:"id"`
+	Code       string         `json:"code"`
+	Version    string         `json:"version"`
+	BackedUpAt time.Time      `json:"backed_up_at"`
+	Row        map[string]any `json:"row"`
+}
+
+// DelMysql 删除t_ds_task_definition_log中重复的(code,version)，db为DolphinScheduler连接池。
+// 任务参数: dry_run=true只返回删除计划；backup=none/table/file覆盖DEL_MYSQL.BACKUP
+func DelMysql(ctx context.Context, db *sql.DB) error {
+	job := JobFromContext(ctx)
+	opts := DelMysqlOptions{
+		DryRun: job.Param("dry_run") == "true",
+		Backup: job.Param("backup"),
+	}
+	if job != nil {
+		opts.BackupID = job.ID
+	}
+	report, err := RunDelMysql(ctx, db, opts)
+	job.SetResult(report)
+	return err
+}
+
+// requireDelMysqlDriver 删除、备份与恢复的SQL(备份表DDL、FOR UPDATE、?占位符)只支持MySQL，
+// DB.DRIVER为postgres时在执行前拒绝，避免删除到一半失败
+func requireDelMysqlDriver() error {
+	cfg, err := DBs.Config(DBDolphinScheduler)
+	if err != nil {
+		return err
+	}
+	if cfg.Driver != "mysql" {
+		return fmt.Errorf("DelMysql只支持MySQL元数据库，当前DB.DRIVER为%s", cfg.Driver)
+	}
+	return nil
+}
+
+// RunDelMysql 按选项生成删除计划或执行删除，每个(code,version)一个事务，备份与删除同时提交
+func RunDelMysql(ctx context.Context, db *sql.DB, opts DelMysqlOptions) (*DelMysqlReport, error) {
+	job := JobFromContext(ctx)
+	if err := requireDelMysqlDriver(); err != nil {
+		return nil, err
+	}
+	if opts.Backup == "" {
+		opts.Backup = viper.GetString("DEL_MYSQL.BACKUP")
+	}
+	if opts.BackupID == "" {
+		opts.BackupID = newJobID()
+	}
+	report := &DelMysqlReport{DryRun: opts.DryRun, Backup: opts.Backup, Groups: []DelMysqlGroup{}}
+
+	groups, err := duplicateGroups(ctx, db)
+	if err != nil {
+		return report, err
+	}
+	job.SetTotal(int64(len(groups)))
+	slog.InfoContext(ctx, "发现重复任务定义", "groups", len(groups), "dry_run", opts.DryRun)
+
+	if opts.DryRun {
+		for _, g := range groups {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			group, err := planGroup(ctx, db, g[0], g[1], false)
+			if err != nil {
+				return report, err
+			}
+			report.Groups = append(report.Groups, group)
+			job.Add("duplicate_groups", 1)
+			job.Add("rows_to_delete", int64(len(group.DeleteIDs)))
+			job.Step(1)
+		}
+		slog.InfoContext(ctx, "删除计划生成完成", "groups", len(report.Groups))
+		return report, nil
+	}
+
+	var backup *os.File
+	switch opts.Backup {
+	case DelMysqlBackupNone:
+	case DelMysqlBackupTable:
+		if _, err := db.ExecContext(ctx, createBackupTable); err != nil {
+			return report, fmt.Errorf("创建备份表失败: %w", err)
+		}
+		report.BackupID = opts.BackupID
+	case DelMysqlBackupFile:
+		dir := viper.GetString("DEL_MYSQL.BACKUP_DIR")
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return report, fmt.Errorf("创建备份目录失败: %w", err)
+		}
+		report.BackupID = opts.BackupID
+		report.BackupFile = filepath.Join(dir, "del_mysql_"+opts.BackupID+".jsonl")
+		// 备份包含调度元数据，仅当前用户可读
+		backup, err = os.OpenFile(report.BackupFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return report, fmt.Errorf("创建备份文件失败: %w", err)
+		}
+		defer backup.Close()
+	default:
+		return report, fmt.Errorf("不支持的备份方式: %s", opts.Backup)
+	}
+
+	for _, g := range groups {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		group, deleted, err := deleteGroup(ctx, db, g[0], g[1], opts, backup)
+		if err != nil {
+			return report, fmt.Errorf("code=%s version=%s: %w", g[0], g[1], err)
+		}
+		report.Groups = append(report.Groups, group)
+		report.RowsDeleted += deleted
+		job.Add("duplicate_groups", 1)
+		job.Add("rows_deleted", deleted)
+		delMysqlRowsDeleted.Add(float64(deleted))
+		job.Step(1)
+	}
+	slog.InfoContext(ctx, "删除完成", "groups", len(report.Groups), "rows_deleted", report.RowsDeleted,
+		"backup", opts.Backup, "backup_id", report.BackupID, "backup_file", report.BackupFile)
+	return report, nil
+}
+
+// duplicateGroups 查询存在重复记录的(code,version)，先读完再逐组处理，避免占用两个连接
+func duplicateGroups(ctx context.Context, db *sql.DB) ([][2]string, error) {
+	rows, err := db.QueryContext(ctx, outerQuery)
+	if err != nil {
+		return nil, fmt.Errorf("查询失败: %w", err)
+	}
+	defer rows.Close()
+
+	var groups [][2]string
+	for rows.Next() {
+		var code, version string
+		var cnt int
+		if err := rows.Scan(&code, &version, &cnt); err != nil {
+			return nil, fmt.Errorf("扫描结果失败: %w", err)
+		}
+		groups = append(groups, [2]string{code, version})
+	}
+	return groups, rows.Err()
+}
+
+// queryer *sql.DB与*sql.Tx共用的查询接口
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// planGroup 计算一组重复记录中保留与删除的id，forUpdate时锁定该组记录
+func planGroup(ctx context.Context, q queryer, code, version string, forUpdate bool) (DelMysqlGroup, error) {
+	group := DelMysqlGroup{Code: code, Version: version, KeepIDs: []int64{}, DeleteIDs: []int64{}}
+	query := innerQuery
+	if forUpdate {
+		query += " for update"
+	}
+	rows, err := q.QueryContext(ctx, query, code, version)
+	if err != nil {
+		return group, fmt.Errorf("内部查询失败: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name, createTime sql.NullString
+		if err := rows.Scan(&id, &name, &createTime); err != nil {
+			return group, fmt.Errorf("扫描内部结果失败: %w", err)
+		}
+		if len(group.KeepIDs) == 0 {
+			group.KeepIDs = append(group.KeepIDs, id)
+			slog.InfoContext(ctx, "保留最新记录", "id", id, "code", code, "version", version, "name", name.String, "create_time", createTime.String)
+			continue
+		}
+		group.DeleteIDs = append(group.DeleteIDs, id)
+		slog.InfoContext(ctx, "待删除重复记录", "id", id, "code", code, "version", version, "name", name.String, "create_time", createTime.String)
+	}
+	return group, rows.Err()
+}
+
+// deleteGroup 在一个事务中重新计算并锁定该组记录、备份并删除，任一步失败整组回滚
+func deleteGroup(ctx context.Context, db *sql.DB, code, version string, opts DelMysqlOptions, backup *os.File) (DelMysqlGroup, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return DelMysqlGroup{}, 0, err
+	}
+	defer tx.Rollback()
+
+	group, err := planGroup(ctx, tx, code, version, true)
+	if err != nil || len(group.DeleteIDs) == 0 {
+		return group, 0, err
+	}
+	placeholders, args := inArgs(group.DeleteIDs)
+
+	if opts.Backup != DelMysqlBackupNone {
+		rows, err := tx.QueryContext(ctx, "select * from t_ds_task_definition_log where id in ("+placeholders+")", args...)
+		if err != nil {
+			return group, 0, fmt.Errorf("读取待删除记录失败: %w", err)
+		}
+		records, err := scanRowMaps(rows)
+		if err != nil {
+			return group, 0, fmt.Errorf("读取待删除记录失败: %w", err)
+		}
+		now := time.Now()
+		for _, rec := range records {
+			id, err := strconv.ParseInt(fmt.Sprint(rec["id"]), 10, 64)
+			if err != nil {
+				return group, 0, fmt.Errorf("解析记录id失败: %w", err)
+			}
+			b := delMysqlBackupRow{BackupID: opts.BackupID, ID: id, Code: code, Version: version, BackedUpAt: now, Row: rec}
+			if err := writeBackupRow(ctx, tx, backup, b); err != nil {
+				return group, 0, fmt.Errorf("备份记录失败: %w", err)
+			}
+		}
+		// 文件备份先落盘再提交删除，提交失败时文件中多出的记录恢复时会被跳过
+		if backup != nil {
+			if err := backup.Sync(); err != nil {
+				return group, 0, fmt.Errorf("备份记录失败: %w", err)
+			}
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM t_ds_task_definition_log WHERE id in ("+placeholders+")", args...)
+	if err != nil {
+		return group, 0, fmt.Errorf("删除操作失败: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return group, 0, fmt.Errorf("提交事务失败: %w", err)
+	}
+	slog.InfoContext(ctx, "删除重复记录", "code", code, "version", version, "ids", group.DeleteIDs, "rows_affected", deleted)
+	return group, deleted, nil
+}
+
+// writeBackupRow 备份一行，backup非空时写入JSONL文件，否则写入备份表
+func writeBackupRow(ctx context.Context, tx *sql.Tx, backup *os.File, b delMysqlBackupRow) error {
+	if backup != nil {
+		line, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		_, err = backup.Write(append(line, '\n'))
+		return err
+	}
+	data, err := json.Marshal(b.Row)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "insert into "+backupTable+
+		" (backup_id, row_id, code, version, row_data, backed_up_at) values (?, ?, ?, ?, ?, ?)",
+		b.BackupID, b.ID, b.Code, b.Version, string(data), b.BackedUpAt)
+	return err
+}
+
+// RestoreDelMysql 从备份恢复被删除的任务定义，source为JSONL备份文件路径或备份表中的backup_id；
+// 恢复在一个事务中完成，id已存在的记录跳过
+func RestoreDelMysql(ctx context.Context, db *sql.DB, source string) (*DelMysqlRestoreReport, error) {
+	if err := requireDelMysqlDriver(); err != nil {
+		return nil, err
+	}
+	report := &DelMysqlRestoreReport{Source: source}
+	fromFile := strings.HasSuffix(source, ".jsonl")
+	if _, err := os.Stat(source); err == nil {
+		fromFile = true
+	}
+
+	var backups []delMysqlBackupRow
+	var err error
+	if fromFile {
+		backups, err = readBackupFile(source)
+	} else {
+		backups, err = readBackupTable(ctx, db, source)
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Total = len(backups)
+	if len(backups) == 0 {
+		return report, fmt.Errorf("备份%s中没有记录", source)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	for _, b := range backups {
+		var exists int
+		err := tx.QueryRowContext(ctx, "select 1 from t_ds_task_definition_log where id = ?", b.ID).Scan(&exists)
+		if err == nil {
+			report.Skipped++
+			slog.WarnContext(ctx, "记录已存在，跳过恢复", "id", b.ID, "code", b.Code, "version", b.Version)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return report, fmt.Errorf("检查记录%d失败: %w", b.ID, err)
+		}
+		if err := insertRowMap(ctx, tx, "t_ds_task_definition_log", b.Row); err != nil {
+			return report, fmt.Errorf("恢复记录%d失败: %w", b.ID, err)
+		}
+		report.Restored++
+	}
+	if !fromFile {
+		if _, err := tx.ExecContext(ctx, "update "+backupTable+" set restored_at = ? where backup_id = ?", time.Now(), source); err != nil {
+			return report, fmt.Errorf("更新备份状态失败: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("提交事务失败: %w", err)
+	}
+	slog.InfoContext(ctx, "恢复完成", "source", source, "total", report.Total, "restored", report.Restored, "skipped", report.Skipped)
+	return report, nil
+}
+
+// readBackupFile 读取JSONL备份文件，数值按json.Number读取避免bigint精度丢失
+func readBackupFile(path string) ([]delMysqlBackupRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开备份文件失败: %w", err)
+	}
+	defer f.Close()
+
+	var backups []delMysqlBackupRow
+	dec := json.NewDecoder(bufio.NewReader(f))
+	dec.UseNumber()
+	for {
+		var b delMysqlBackupRow
+		if err := dec.Decode(&b); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("解析备份文件失败: %w", err)
+		}
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// readBackupTable 读取备份表中指定批次的记录
+func readBackupTable(ctx context.Context, db *sql.DB, backupID string) ([]delMysqlBackupRow, error) {
+	rows, err := db.QueryContext(ctx, "select row_id, code, version, row_data from "+backupTable+" where backup_id = ? order by row_id", backupID)
+	if err != nil {
+		return nil, fmt.Errorf("读取备份表失败: %w", err)
+	}
+	defer rows.Close()
+
+	var backups []delMysqlBackupRow
+	for rows.Next() {
+		b := delMysqlBackupRow{BackupID: backupID}
+		var data string
+		if err := rows.Scan(&b.ID, &b.Code, &b.Version, &data); err != nil {
+			return nil, fmt.Errorf("读取备份表失败: %w", err)
+		}
+		dec := json.NewDecoder(strings.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&b.Row); err != nil {
+			return nil, fmt.Errorf("解析备份记录%d失败: %w", b.ID, err)
+		}
+		backups = append(backups, b)
+	}
+	return backups, rows.Err()
+}
+
+// scanRowMaps 将结果集按列名读取为map，[]byte与时间转为字符串便于JSON序列化及原样写回
+func scanRowMaps(rows *sql.Rows) ([]map[string]any, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]any
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		rec := make(map[string]any, len(cols))
+		for i, col := range cols {
+			switch v := values[i].(type) {
+			case []byte:
+				rec[col] = string(v)
+			case time.Time:
+				rec[col] = v.Format("2006-01-02 15:04:05.999999")
+			default:
+				rec[col] = v
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+var identPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// insertRowMap 按列名插入一行，列名来自备份数据，校验后才拼入SQL
+func insertRowMap(ctx context.Context, tx *sql.Tx, table string, row map[string]any) error {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		if !identPattern.MatchString(col) {
+			return fmt.Errorf("非法列名: %q", col)
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	args := make([]any, len(cols))
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = "`" + col + "`"
+		if n, ok := row[col].(json.Number); ok {
+			args[i] = n.String()
+		} else {
+			args[i] = row[col]
+		}
+	}
+	_, err := tx.ExecContext(ctx, "insert into "+table+" ("+strings.Join(quoted, ", ")+") values ("+
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")+")", args...)
+	return err
+}
+
+// inArgs 生成IN子句占位符与参数
+func inArgs(ids []int64) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
@@ -0,0 +1,234 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// 门诊指标结果的对账模式
+const (
+	MZReconcileStrict  = "strict"  // 任何不一致都丢弃整批结果并重新请求整批
+	MZReconcilePartial = "partial" // 接受匹配的指标，只重新请求缺少的指标
+)
+
+// 指标不一致的类型
+const (
+	discrepancyUnknownCode    = "unknown_code"    // 返回了未请求的code，丢弃
+	discrepancyDuplicate      = "duplicate"       // 同一code返回多次，保留第一个
+	discrepancyNameMismatch   = "name_mismatch"   // name归一化后仍不一致，丢弃并重新请求
+	discrepancyNameNormalized = "name_normalized" // name仅有空白、全半角、标点或大小写差异，接受并使用传入的name
+	discrepancyMissing        = "missing"         // 所有调用后仍缺少的code
+)
+
+// 指标不一致记录，写入pg_struct库，用于调整提示词
+const createMZDiscrepancySQL = `
+CREATE TABLE IF NOT EXISTS public.mz_indicator_discrepancy (
+	id             bigserial PRIMARY KEY,
+	job_id         varchar(64),
+	encounter_id   varchar(64) NOT NULL,
+	fsjd           integer NOT NULL,
+	call_no        integer NOT NULL,
+	kind           varchar(32) NOT NULL,
+	code           varchar(128),
+	expected_name  text,
+	returned_name  text,
+	returned_value text,
+	created_at     timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_mz_indicator_discrepancy_created_at ON public.mz_indicator_discrepancy (created_at);
+CREATE INDEX IF NOT EXISTS idx_mz_indicator_discrepancy_code ON public.mz_indicator_discrepancy (code);`
+
+// IndicatorDiscrepancy 一个返回指标与请求指标的不一致
+type IndicatorDiscrepancy struct {
+	Call          int
+	Kind          string
+	Code          string
+	ExpectedName  string
+	ReturnedName  string
+	ReturnedValue string
+}
+
+// indicatorReconciliation 一次调用的对账结果，Missing为需要重新请求的指标(含name不一致被丢弃的)
+type indicatorReconciliation struct {
+	Accepted      []Indicator
+	Missing       []Indicator
+	Discrepancies []IndicatorDiscrepancy
+}
+
+// reconcileIndicators 按code将返回指标与请求指标对账，接受的指标使用请求中的name
+func reconcileIndicators(call int, requested, returned []Indicator) indicatorReconciliation {
+	var rec indicatorReconciliation
+	names := make(map[string]string, len(requested))
+	for _, ind := range requested {
+		names[ind.Code] = ind.Name
+	}
+	seen := make(map[string]bool, len(returned))
+	for _, res := range returned {
+		d := IndicatorDiscrepancy{Call: call, Code: res.Code, ReturnedName: res.Name, ReturnedValue: res.Value}
+		expected, ok := names[res.Code]
+		switch {
+		case !ok:
+			d.Kind = discrepancyUnknownCode
+		case seen[res.Code]:
+			d.Kind, d.ExpectedName = discrepancyDuplicate, expected
+		case res.Name != expected && normalizeIndicatorName(res.Name) != normalizeIndicatorName(expected):
+			d.Kind, d.ExpectedName = discrepancyNameMismatch, expected
+		default:
+			seen[res.Code] = true
+			if res.Name != expected {
+				rec.Discrepancies = append(rec.Discrepancies, IndicatorDiscrepancy{Call: call, Kind: discrepancyNameNormalized,
+					Code: res.Code, ExpectedName: expected, ReturnedName: res.Name})
+				res.Name = expected
+			}
+			rec.Accepted = append(rec.Accepted, res)
+			continue
+		}
+		rec.Discrepancies = append(rec.Discrepancies, d)
+	}
+	for _, ind := range requested {
+		if !seen[ind.Code] {
+			rec.Missing = append(rec.Missing, ind)
+		}
+	}
+	return rec
+}
+
+// normalizeIndicatorName 全角转半角，去掉空白与标点，转小写，如"血压（收缩压）"与"血压(收缩压)"相同
+func normalizeIndicatorName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// mzRequest 一次就诊一个fsjd阶段的指标请求
+type mzRequest struct {
+	db                 *sql.DB
+	visit              PatientVisitMZ
+	fsjd               int
+	storeDiscrepancies bool
+}
+
+// requestIndicators 请求一批指标的结果，最多调用MZ.MAX_CALLS次。partial模式接受匹配的指标，
+// 后续调用只请求缺少的指标；strict模式任何不一致都重新请求整批。传输错误已在Dify网关中重试，
+// 只有模型输出不可用时才再次调用。部分指标最终缺少时仍返回已接受的指标
+func (r *mzRequest) requestIndicators(ctx context.Context, batch []Indicator) ([]Indicator, error) {
+	job := JobFromContext(ctx)
+	strict := viper.GetString("MZ.RECONCILE") == MZReconcileStrict
+	maxCalls := max(viper.GetInt("MZ.MAX_CALLS"), 1)
+
+	pending := batch
+	var accepted []Indicator
+	var lastErr error
+	call := 0
+	for call < maxCalls && len(pending) > 0 {
+		call++
+		if call > 1 {
+			countPipeline(job, JobProcessMZ, "followup_calls", 1)
+		}
+		indicatorJSON, err := json.Marshal(pending)
+		if err != nil {
+			return accepted, err
+		}
+		result, err := RunWorkflowWithSDK_MZ(ctx, r.visit.Content, string(indicatorJSON))
+		if err != nil {
+			lastErr = err
+			if !mzOutputUnusable(err) {
+				break
+			}
+			slog.WarnContext(ctx, "门诊指标结果不可用", "call", call, "max_calls", maxCalls, "error", err)
+			if call < maxCalls && sleepContext(ctx, 2*time.Second) != nil {
+				break
+			}
+			continue
+		}
+
+		rec := reconcileIndicators(call, pending, result)
+		r.recordDiscrepancies(ctx, rec.Discrepancies)
+		if strict && (len(rec.Discrepancies) > 0 || len(rec.Missing) > 0) {
+			difyFailuresTotal.WithLabelValues("mz_workflow", "mismatch").Inc()
+			lastErr = &paramError{message: fmt.Sprintf("结果指标与传入指标不匹配: %d处不一致，缺少%d个", len(rec.Discrepancies), len(rec.Missing))}
+			slog.WarnContext(ctx, "门诊指标结果与请求不一致，重新请求整批", "call", call,
+				"discrepancies", len(rec.Discrepancies), "missing", len(rec.Missing))
+			continue
+		}
+		accepted = append(accepted, rec.Accepted...)
+		if len(rec.Missing) > 0 {
+			slog.InfoContext(ctx, "门诊指标结果部分缺少", "call", call, "accepted", len(rec.Accepted), "missing", len(rec.Missing))
+		}
+		pending = rec.Missing
+		lastErr = nil
+	}
+
+	if len(accepted) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	if len(pending) > 0 {
+		missing := make([]IndicatorDiscrepancy, len(pending))
+		for i, ind := range pending {
+			missing[i] = IndicatorDiscrepancy{Call: call, Kind: discrepancyMissing, Code: ind.Code, ExpectedName: ind.Name}
+		}
+		r.recordDiscrepancies(ctx, missing)
+		countPipeline(job, JobProcessMZ, "indicators_missing", int64(len(pending)))
+	}
+	return accepted, nil
+}
+
+// mzOutputUnusable 工作流执行失败或模型输出无法解析，再次调用可能得到可用结果
+func mzOutputUnusable(err error) bool {
+	var pe *paramError
+	var le *LLMParseError
+	return errors.As(err, &pe) || errors.As(err, &le)
+}
+
+// recordDiscrepancies 记录不一致的指标数与日志，启用MZ.STORE_DISCREPANCIES时写入mz_indicator_discrepancy表
+func (r *mzRequest) recordDiscrepancies(ctx context.Context, ds []IndicatorDiscrepancy) {
+	if len(ds) == 0 {
+		return
+	}
+	job := JobFromContext(ctx)
+	for _, d := range ds {
+		mzIndicatorDiscrepanciesTotal.WithLabelValues(d.Kind).Inc()
+		if d.Kind != discrepancyNameNormalized && d.Kind != discrepancyMissing {
+			countPipeline(job, JobProcessMZ, "indicators_discarded", 1)
+		}
+		slog.DebugContext(ctx, "门诊指标不一致", "call", d.Call, "kind", d.Kind, "code", d.Code,
+			"expected_name", d.ExpectedName, "returned_name", d.ReturnedName)
+	}
+	if !r.storeDiscrepancies {
+		return
+	}
+	var jobID string
+	if job != nil {
+		jobID = job.ID
+	}
+	wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	for _, d := range ds {
+		_, err := r.db.ExecContext(wctx, `
+			INSERT INTO public.mz_indicator_discrepancy (job_id, encounter_id, fsjd, call_no, kind, code,
+				expected_name, returned_name, returned_value)
+			VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''))`,
+			jobID, r.visit.EncounterId, r.fsjd, d.Call, d.Kind, d.Code, d.ExpectedName, d.ReturnedName, d.ReturnedValue)
+		if err != nil {
+			slog.WarnContext(ctx, "写入门诊指标不一致记录失败", "error", err)
+			return
+		}
+	}
+}
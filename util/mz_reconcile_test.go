@@ -0,0 +1,158 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeIndicatorName(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"血压（收缩压）", "血压(收缩压)", true},
+		{"血压 收缩压", "血压　收缩压", true},
+		{" 空腹血糖\t", "空腹血糖", true},
+		{"ＨＧＢ", "hgb", true},
+		{"HbA1c", "ＨＢＡ１Ｃ", true},
+		{"乙肝表面抗原，HBsAg。", "乙肝表面抗原-HBsAg", true},
+		{"总胆红素/TBIL", "总胆红素、TBIL", true},
+		{"体温℃", "体温", false},
+		{"收缩压", "舒张压", false},
+		{"HbA1c", "HbA2c", false},
+	}
+	for _, tt := range tests {
+		if got := normalizeIndicatorName(tt.a) == normalizeIndicatorName(tt.b); got != tt.equal {
+			t.Errorf("normalizeIndicatorName(%q) = %q, normalizeIndicatorName(%q) = %q, equal应为%v",
+				tt.a, normalizeIndicatorName(tt.a), tt.b, normalizeIndicatorName(tt.b), tt.equal)
+		}
+	}
+}
+
+func TestReconcileIndicators(t *testing.T) {
+	requested := []Indicator{
+		{Code: "001", Name: "血压（收缩压）"},
+		{Code: "002", Name: "空腹血糖"},
+		{Code: "003", Name: "HbA1c"},
+	}
+	tests := []struct {
+		name         string
+		returned     []Indicator
+		wantAccepted []Indicator
+		wantMissing  []string
+		wantKinds    []string
+		wantCodes    []string // 不一致记录的code，与wantKinds一一对应
+	}{
+		{
+			name: "完全匹配",
+			returned: []Indicator{
+				{Code: "001", Name: "血压（收缩压）", Value: "120"},
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+			wantAccepted: []Indicator{
+				{Code: "001", Name: "血压（收缩压）", Value: "120"},
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+		},
+		{
+			name: "name归一化后一致时接受并使用请求的name",
+			returned: []Indicator{
+				{Code: "001", Name: "血压(收缩压)", Value: "120"},
+				{Code: "002", Name: " 空腹 血糖 ", Value: "5.1"},
+				{Code: "003", Name: "ＨＢＡ１Ｃ", Value: "6.0"},
+			},
+			wantAccepted: []Indicator{
+				{Code: "001", Name: "血压（收缩压）", Value: "120"},
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+			wantKinds: []string{discrepancyNameNormalized, discrepancyNameNormalized, discrepancyNameNormalized},
+			wantCodes: []string{"001", "002", "003"},
+		},
+		{
+			name: "name不一致时丢弃并重新请求",
+			returned: []Indicator{
+				{Code: "001", Name: "舒张压", Value: "80"},
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+			wantAccepted: []Indicator{
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+			wantMissing: []string{"001"},
+			wantKinds:   []string{discrepancyNameMismatch},
+			wantCodes:   []string{"001"},
+		},
+		{
+			name: "未请求的code丢弃",
+			returned: []Indicator{
+				{Code: "001", Name: "血压（收缩压）", Value: "120"},
+				{Code: "999", Name: "尿酸", Value: "300"},
+				{Code: "", Name: "空腹血糖", Value: "5.1"},
+			},
+			wantAccepted: []Indicator{{Code: "001", Name: "血压（收缩压）", Value: "120"}},
+			wantMissing:  []string{"002", "003"},
+			wantKinds:    []string{discrepancyUnknownCode, discrepancyUnknownCode},
+			wantCodes:    []string{"999", ""},
+		},
+		{
+			name: "重复的code保留第一个",
+			returned: []Indicator{
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "002", Name: "空腹血糖", Value: "7.9"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+			wantAccepted: []Indicator{
+				{Code: "002", Name: "空腹血糖", Value: "5.1"},
+				{Code: "003", Name: "HbA1c", Value: "6.0"},
+			},
+			wantMissing: []string{"001"},
+			wantKinds:   []string{discrepancyDuplicate},
+			wantCodes:   []string{"002"},
+		},
+		{
+			name: "name不一致的重复code后出现正确的",
+			returned: []Indicator{
+				{Code: "001", Name: "舒张压", Value: "80"},
+				{Code: "001", Name: "血压 (收缩压)", Value: "120"},
+			},
+			wantAccepted: []Indicator{{Code: "001", Name: "血压（收缩压）", Value: "120"}},
+			wantMissing:  []string{"002", "003"},
+			wantKinds:    []string{discrepancyNameMismatch, discrepancyNameNormalized},
+			wantCodes:    []string{"001", "001"},
+		},
+		{
+			name:        "没有返回",
+			wantMissing: []string{"001", "002", "003"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := reconcileIndicators(2, requested, tt.returned)
+			if !reflect.DeepEqual(rec.Accepted, tt.wantAccepted) {
+				t.Errorf("Accepted = %+v, want %+v", rec.Accepted, tt.wantAccepted)
+			}
+			var missing []string
+			for _, ind := range rec.Missing {
+				missing = append(missing, ind.Code)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("Missing = %v, want %v", missing, tt.wantMissing)
+			}
+			var kinds, codes []string
+			for _, d := range rec.Discrepancies {
+				if d.Call != 2 {
+					t.Errorf("Discrepancy.Call = %d, want 2", d.Call)
+				}
+				kinds = append(kinds, d.Kind)
+				codes = append(codes, d.Code)
+			}
+			if !reflect.DeepEqual(kinds, tt.wantKinds) || !reflect.DeepEqual(codes, tt.wantCodes) {
+				t.Errorf("Discrepancies = %v %v, want %v %v", kinds, codes, tt.wantKinds, tt.wantCodes)
+			}
+		})
+	}
+}
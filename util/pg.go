@@ -4,11 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
 	"sync"
 
-	"github.com/spf13/viper"
-	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PatientVisit 患者访视信息结构体
@@ -19,34 +20,12 @@ type PatientVisit struct {
 	Content     string `json:"content"`
 }
 
-// ProcessVisits 处理访视记录的主函数
-func ProcessVisits() error {
-	// 从配置加载数据库连接信息
-	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		viper.GetString("pg_struct.HOST"),
-		viper.GetString("pg_struct.PORT"),
-		viper.GetString("pg_struct.USER"),
-		viper.GetString("pg_struct.PASSWORD"),
-		viper.GetString("pg_struct.DATABASE"),
-	)
-
-	// 建立数据库连接（用于查询）
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Printf("无法打开数据库连接: %v", err)
-		return err
-	}
-	defer db.Close()
-
-	if err := db.Ping(); err != nil {
-		log.Printf("数据库连接失败: %v", err)
-		return err
-	}
-	log.Println("数据库连接成功")
+// ProcessVisits 处理访视记录的主函数，db为pg_struct连接池
+func ProcessVisits(ctx context.Context, db *sql.DB) error {
+	job := JobFromContext(ctx)
 
 	// 查询需要处理的记录
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT encounter_id, person_id, patient_id, CONCAT_WS(
 		'; ',
 		'患者姓名: ' || patient_name,
@@ -58,7 +37,7 @@ func ProcessVisits() error {
 		'诊断: ' || COALESCE(diag_name, '无诊断信息')
 	) AS "content" FROM public.dc_mr_document_index_outpat where deleted_flag is null;`)
 	if err != nil {
-		log.Printf("查询失败: %v", err)
+		slog.ErrorContext(ctx, "查询失败", "error", err)
 		return err
 	}
 	defer rows.Close()
@@ -67,85 +46,87 @@ func ProcessVisits() error {
 	for rows.Next() {
 		var visit PatientVisit
 		if err := rows.Scan(&visit.EncounterId, &visit.PersonId, &visit.PatientId, &visit.Content); err != nil {
-			log.Printf("扫描记录失败: %v", err)
+			slog.ErrorContext(ctx, "扫描记录失败", "error", err)
 			return err
 		}
 		visits = append(visits, visit)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("行迭代错误: %v", err)
+		slog.ErrorContext(ctx, "行迭代错误", "error", err)
 		return err
 	}
 
-	log.Printf("查询到 %d 条需要处理的记录", len(visits))
+	slog.InfoContext(ctx, "查询到需要处理的记录", "count", len(visits))
+	job.SetTotal(int64(len(visits)))
 	if len(visits) == 0 {
 		return nil
 	}
 
 	// 处理每条记录 - 多线程版本
 	// 创建上下文，可用于取消操作
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// 配置工作池参数
-	workerCount := 5 // 工作线程数量，可根据实际情况调整
+	workerCount := 5                                  // 工作线程数量，可根据实际情况调整
 	visitChan := make(chan PatientVisit, len(visits)) // 任务通道
-	var wg sync.WaitGroup // 等待组，用于同步所有工作线程
+	var wg sync.WaitGroup                             // 等待组，用于同步所有工作线程
 
 	// 启动工作线程池
 	for i := 0; i < workerCount; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			
-			// 为每个工作线程创建独立的数据库连接
-			workerDB, err := sql.Open("postgres", connStr)
-			if err != nil {
-				log.Printf("工作线程 %d 无法创建数据库连接: %v", workerID, err)
-				return
-			}
-			defer workerDB.Close()
-			
-			if err := workerDB.Ping(); err != nil {
-				log.Printf("工作线程 %d 数据库连接失败: %v", workerID, err)
-				return
-			}
-			
+			logger := slog.With("worker", workerID)
+
 			// 处理从通道接收的任务
 			for {
 				select {
 				case <-ctx.Done():
-					log.Printf("工作线程 %d 收到停止信号", workerID)
+					logger.InfoContext(ctx, "工作线程收到停止信号")
 					return
 				case visit, ok := <-visitChan:
 					if !ok {
 						// 通道已关闭，无更多任务
-						log.Printf("工作线程 %d 完成所有任务", workerID)
+						logger.DebugContext(ctx, "工作线程完成所有任务")
 						return
 					}
-					
-					log.Printf("工作线程 %d 处理记录 encounter_id=%s", workerID, visit.EncounterId)
-					
+
+					vlog := logger.With("encounter_id", visit.EncounterId)
+					vctx, span := tracer.Start(ctx, "visit", trace.WithAttributes(attribute.String("encounter_id", visit.EncounterId)))
+					vctx = WithDifyUsage(vctx, visit.EncounterId, "")
+					vlog.DebugContext(vctx, "处理记录")
+
 					// 调用Dify API
-					resultData, err := RunWorkflowWithSDK(fmt.Sprintf("%s", visit.Content))
+					resultData, err := RunWorkflowWithSDK(vctx, visit.Content)
+					job.Step(1)
+					countPipeline(job, JobProcessVisits, "processed", 1)
 					if err != nil {
-						log.Printf("工作线程 %d 获取阶段失败 (encounter_id=%s): %v", workerID, visit.EncounterId, err)
+						vlog.ErrorContext(vctx, "获取阶段失败", "error", err)
+						countPipeline(job, JobProcessVisits, "failed", 1)
+						job.RecordError(fmt.Errorf("encounter_id=%s: %w", visit.EncounterId, err))
+						endSpan(span, err)
 						continue
 					}
-					
+
 					// 更新数据库
-					result, err := workerDB.ExecContext(ctx, `
-						UPDATE public.dc_mr_document_index_outpat SET deleted_flag = $1,patient_external = $2 WHERE encounter_id = $3 and person_id = $4 and patient_id = $5;`, resultData.VisitNumber,resultData.GestationalWeeks, visit.EncounterId, visit.PersonId, visit.PatientId)
-					
+					result, err := db.ExecContext(vctx, `
+						UPDATE public.dc_mr_document_index_outpat SET deleted_flag = $1,patient_external = $2 WHERE encounter_id = $3 and person_id = $4 and patient_id = $5;`, resultData.VisitNumber, resultData.GestationalWeeks, visit.EncounterId, visit.PersonId, visit.PatientId)
+
 					if err != nil {
-						log.Printf("工作线程 %d 更新失败 (encounter_id=%s): %v", workerID, visit.EncounterId, err)
+						vlog.ErrorContext(vctx, "更新失败", "error", err)
+						countPipeline(job, JobProcessVisits, "failed", 1)
+						job.RecordError(fmt.Errorf("encounter_id=%s: %w", visit.EncounterId, err))
+						endSpan(span, err)
 						continue
 					}
-					
+
 					if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
-						log.Printf("工作线程 %d 成功更新记录 encounter_id=%s", workerID, visit.EncounterId)
+						vlog.DebugContext(vctx, "成功更新记录")
+						countPipeline(job, JobProcessVisits, "updated", rowsAffected)
 					}
+					span.End()
 				}
 			}
 		}(i)
@@ -160,38 +141,22 @@ func ProcessVisits() error {
 	// 等待所有工作线程完成
 	wg.Wait()
 
-	log.Println("所有记录处理完毕")
+	if err := ctx.Err(); err != nil {
+		slog.WarnContext(ctx, "记录处理被中断", "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "所有记录处理完毕")
 	return nil
 }
 
-// ValidatePatientData 校验t_patient_data表中的数据格式
-func ValidatePatientData() error {
-	// 从配置加载数据库连接信息
-	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		viper.GetString("pg_struct.HOST"),
-		viper.GetString("pg_struct.PORT"),
-		viper.GetString("pg_struct.USER"),
-		viper.GetString("pg_struct.PASSWORD"),
-		viper.GetString("pg_struct.DATABASE"),
-	)
-
-	// 建立数据库连接
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Printf("无法打开数据库连接: %v", err)
-		return err
-	}
-	defer db.Close()
+// ValidatePatientData 校验t_patient_data表中的数据格式，db为pg_struct连接池
+func ValidatePatientData(ctx context.Context, db *sql.DB) error {
+	job := JobFromContext(ctx)
 
-	if err := db.Ping(); err != nil {
-		log.Printf("数据库连接失败: %v", err)
-		return err
-	}
-	log.Println("数据库连接成功，开始校验t_patient_data数据")
+	slog.InfoContext(ctx, "数据库连接成功，开始校验t_patient_data数据")
 
 	// 查询需要校验的数据
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT 
 			t.p_id,
 			t.field_id,
@@ -203,7 +168,7 @@ func ValidatePatientData() error {
 		LEFT JOIN public.t_model_view tmv ON 
 			t.field_id = tmv.id;`)
 	if err != nil {
-		log.Printf("查询失败: %v", err)
+		slog.ErrorContext(ctx, "查询失败", "error", err)
 		return err
 	}
 	defer rows.Close()
@@ -221,9 +186,11 @@ func ValidatePatientData() error {
 		var isMultiChoice sql.NullString
 
 		if err := rows.Scan(&pID, &fieldID, &value, &valueType, &isMultiChoice); err != nil {
-			log.Printf("扫描记录失败: %v", err)
+			slog.ErrorContext(ctx, "扫描记录失败", "error", err)
+			job.RecordError(err)
 			continue
 		}
+		job.Add("checked", 1)
 
 		// 标记为无效的标志
 		isInvalid := false
@@ -233,27 +200,28 @@ func ValidatePatientData() error {
 		if valueType == 4 && isMultiChoice.Valid && isMultiChoice.String == "单选" {
 			// 检查value是否为整数
 			if !isInteger(value) {
-				log.Printf("校验失败: p_id=%s, field_id=%s, value=%s (value_type=4, is_multi_choice='单选'，要求整数)", pID, fieldID, value)
+				slog.DebugContext(ctx, "校验失败: 单选值要求整数", "p_id", pID, "field_id", fieldID, "value", Redact(value), "value_type", valueType)
 				isInvalid = true
 			}
-		// 校验2: value_type=3，value格式必须是2001/01/01
+			// 校验2: value_type=3，value格式必须是2001/01/01
 		} else if valueType == 3 {
 			// 检查value是否为YYYY/MM/DD格式
 			if !isValidDateFormat(value) {
-				log.Printf("校验失败: p_id=%s, field_id=%s, value=%s (value_type=3，要求YYYY/MM/DD格式)", pID, fieldID, value)
+				slog.DebugContext(ctx, "校验失败: 要求YYYY/MM/DD格式", "p_id", pID, "field_id", fieldID, "value", Redact(value), "value_type", valueType)
 				isInvalid = true
 			}
-		// 校验3: value_type=1，value必须是int
+			// 校验3: value_type=1，value必须是int
 		} else if valueType == 1 {
 			// 检查value是否为整数
 			if !isInteger(value) {
-				log.Printf("校验失败: p_id=%s, field_id=%s, value=%s (value_type=1，要求整数)", pID, fieldID, value)
+				slog.DebugContext(ctx, "校验失败: 要求整数", "p_id", pID, "field_id", fieldID, "value", Redact(value), "value_type", valueType)
 				isInvalid = true
 			}
 		}
 
 		// 如果校验失败，添加到无效记录列表
 		if isInvalid {
+			invalidRecordsTotal.WithLabelValues(strconv.Itoa(valueType)).Inc()
 			invalidRecords = append(invalidRecords, struct {
 				pID     string
 				fieldID string
@@ -262,28 +230,29 @@ func ValidatePatientData() error {
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("行迭代错误: %v", err)
+		slog.ErrorContext(ctx, "行迭代错误", "error", err)
 		return err
 	}
 
-	log.Printf("共发现 %d 条无效记录需要标记为删除", len(invalidRecords))
+	slog.InfoContext(ctx, "发现无效记录需要标记为删除", "count", len(invalidRecords))
+	job.Add("invalid", int64(len(invalidRecords)))
 
 	// 批量更新无效记录的del_flag为1
 	if len(invalidRecords) > 0 {
 		// 使用事务来提高性能和确保原子性
-		tx, err := db.Begin()
+		tx, err := db.BeginTx(ctx, nil)
 		if err != nil {
-			log.Printf("创建事务失败: %v", err)
+			slog.ErrorContext(ctx, "创建事务失败", "error", err)
 			return err
 		}
 
 		// 准备更新语句
-		stmt, err := tx.Prepare(`
+		stmt, err := tx.PrepareContext(ctx, `
 			UPDATE public.t_patient_data 
 			SET del_flag = 1 
 			WHERE p_id = $1 AND field_id = $2`)
 		if err != nil {
-			log.Printf("准备更新语句失败: %v", err)
+			slog.ErrorContext(ctx, "准备更新语句失败", "error", err)
 			tx.Rollback()
 			return err
 		}
@@ -291,9 +260,9 @@ func ValidatePatientData() error {
 
 		// 批量执行更新
 		for _, record := range invalidRecords {
-			_, err := stmt.Exec(record.pID, record.fieldID)
+			_, err := stmt.ExecContext(ctx, record.pID, record.fieldID)
 			if err != nil {
-				log.Printf("更新记录失败 (p_id=%s, field_id=%s): %v", record.pID, record.fieldID, err)
+				slog.ErrorContext(ctx, "更新记录失败", "p_id", record.pID, "field_id", record.fieldID, "error", err)
 				tx.Rollback()
 				return err
 			}
@@ -301,15 +270,16 @@ func ValidatePatientData() error {
 
 		// 提交事务
 		if err := tx.Commit(); err != nil {
-			log.Printf("提交事务失败: %v", err)
+			slog.ErrorContext(ctx, "提交事务失败", "error", err)
 			tx.Rollback()
 			return err
 		}
 
-		log.Printf("成功将 %d 条无效记录标记为删除", len(invalidRecords))
+		slog.InfoContext(ctx, "无效记录已标记为删除", "count", len(invalidRecords))
+		job.Add("marked_deleted", int64(len(invalidRecords)))
 	}
 
-	log.Println("t_patient_data数据校验完成")
+	slog.InfoContext(ctx, "t_patient_data数据校验完成")
 	return nil
 }
 
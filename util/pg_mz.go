@@ -3,15 +3,15 @@ package util
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"log"
-	"sync"
+	"log/slog"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/spf13/viper"
-	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PatientVisitMZ 患者访视信息结构体
@@ -30,45 +30,31 @@ type Indicator struct {
 	ValueExplain string `json:"value_explain"`
 }
 
-// ProcessMZMain 批量处理不同deleted_flag的记录
-func ProcessMZMain() error {
+// ProcessMZMain 批量处理不同deleted_flag的记录，共用同一个连接池
+func ProcessMZMain(ctx context.Context, db *sql.DB) error {
+	storeDiscrepancies := viper.GetBool("MZ.STORE_DISCREPANCIES")
+	if storeDiscrepancies {
+		if _, err := db.ExecContext(ctx, createMZDiscrepancySQL); err != nil {
+			// 不影响指标提取
+			slog.WarnContext(ctx, "创建mz_indicator_discrepancy表失败，不记录不一致的指标", "error", err)
+			storeDiscrepancies = false
+		}
+	}
 	for i := 1; i <= 6; i++ {
-		if err := ProcessMZ(i); err != nil {
-			log.Printf("处理访视记录失败(deleted_flag=%d): %v", i, err)
+		if err := ProcessMZ(ctx, db, i, storeDiscrepancies); err != nil {
+			slog.ErrorContext(ctx, "处理访视记录失败", "deleted_flag", i, "error", err)
 			return err
 		}
 	}
 	return nil
 }
 
-// ProcessMZ 处理访视记录的主函数
-func ProcessMZ(deletedFlag int) error {
-	// 从配置加载数据库连接信息
-	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		viper.GetString("pg_struct.HOST"),
-		viper.GetString("pg_struct.PORT"),
-		viper.GetString("pg_struct.USER"),
-		viper.GetString("pg_struct.PASSWORD"),
-		viper.GetString("pg_struct.DATABASE"),
-	)
-
-	// 建立数据库连接（用于查询）
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Printf("无法打开数据库连接: %v", err)
-		return err
-	}
-	defer db.Close()
-
-	if err := db.Ping(); err != nil {
-		log.Printf("数据库连接失败: %v", err)
-		return err
-	}
-	log.Println("数据库连接成功")
+// ProcessMZ 处理访视记录的主函数，db为pg_struct连接池，storeDiscrepancies为是否写入不一致的指标
+func ProcessMZ(ctx context.Context, db *sql.DB, deletedFlag int, storeDiscrepancies bool) error {
+	job := JobFromContext(ctx)
 
 	// 查询需要处理的记录
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT encounter_id, person_id, patient_id, CONCAT_WS(
 		'; ',
 		'患者姓名: ' || patient_name,
@@ -82,7 +68,7 @@ func ProcessMZ(deletedFlag int) error {
 		FROM public.dc_mr_document_index_outpat 
 		WHERE deleted_flag = $1;`, deletedFlag)
 	if err != nil {
-		log.Printf("查询失败: %v", err)
+		slog.ErrorContext(ctx, "查询失败", "error", err)
 		return err
 	}
 	defer rows.Close()
@@ -91,83 +77,79 @@ func ProcessMZ(deletedFlag int) error {
 	for rows.Next() {
 		var visit PatientVisitMZ
 		if err := rows.Scan(&visit.EncounterId, &visit.PersonId, &visit.PatientId, &visit.Content); err != nil {
-			log.Printf("扫描记录失败: %v", err)
+			slog.ErrorContext(ctx, "扫描记录失败", "error", err)
 			return err
 		}
 		visits = append(visits, visit)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("行迭代错误: %v", err)
+		slog.ErrorContext(ctx, "行迭代错误", "error", err)
 		return err
 	}
 
-	log.Printf("查询到 %d 条需要处理的记录", len(visits))
+	slog.InfoContext(ctx, "查询到需要处理的记录", "count", len(visits))
+	job.AddTotal(int64(len(visits)))
 	if len(visits) == 0 {
 		return nil
 	}
 
 	// 处理每条记录 - 多线程版本
 	// 创建上下文，可用于取消操作
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// 配置工作池参数
-	workerCount := 5 // 工作线程数量，可根据实际情况调整
+	workerCount := 5                                    // 工作线程数量，可根据实际情况调整
 	visitChan := make(chan PatientVisitMZ, len(visits)) // 任务通道
-	var wg sync.WaitGroup // 等待组，用于同步所有工作线程
+	var wg sync.WaitGroup                               // 等待组，用于同步所有工作线程
 
 	// 启动工作线程池
 	for i := 0; i < workerCount; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-
-			// 为每个工作线程创建独立的数据库连接
-			workerDB, err := sql.Open("postgres", connStr)
-			if err != nil {
-				log.Printf("工作线程 %d 无法创建数据库连接: %v", workerID, err)
-				return
-			}
-			defer workerDB.Close()
-
-			if err := workerDB.Ping(); err != nil {
-				log.Printf("工作线程 %d 数据库连接失败: %v", workerID, err)
-				return
-			}
+			logger := slog.With("worker", workerID, "deleted_flag", deletedFlag)
 
 			// 处理从通道接收的任务
 			for {
 				select {
 				case <-ctx.Done():
-					log.Printf("工作线程 %d 收到停止信号", workerID)
+					logger.InfoContext(ctx, "工作线程收到停止信号")
 					return
 				case visit, ok := <-visitChan:
 					if !ok {
 						// 通道已关闭，无更多任务
-						log.Printf("工作线程 %d 完成所有任务", workerID)
+						logger.DebugContext(ctx, "工作线程完成所有任务")
 						return
 					}
 
-					log.Printf("工作线程 %d 处理记录 encounter_id=%s", workerID, visit.EncounterId)
+					vlog := logger.With("encounter_id", visit.EncounterId)
+					vctx, span := tracer.Start(ctx, "visit", trace.WithAttributes(attribute.String("encounter_id", visit.EncounterId)))
+					// 用量按fsjd阶段统计
+					vctx = WithDifyUsage(vctx, visit.EncounterId, strconv.Itoa(deletedFlag))
+					vlog.DebugContext(vctx, "处理记录")
+					job.Step(1)
+					countPipeline(job, JobProcessMZ, "processed", 1)
 
 					// 查询数据库获取指标数据
-					indsRows, err := workerDB.QueryContext(ctx, `
+					indsRows, err := db.QueryContext(vctx, `
 						SELECT id as code, "name", COALESCE(null, '') as value, aliass as value_explain 
 						FROM public.t_model_view 
 						WHERE fsjd = $1;`, deletedFlag)
 					if err != nil {
-						log.Printf("工作线程 %d 查询指标失败 (encounter_id=%s): %v", workerID, visit.EncounterId, err)
+						vlog.ErrorContext(vctx, "查询指标失败", "error", err)
+						job.RecordError(fmt.Errorf("encounter_id=%s: %w", visit.EncounterId, err))
+						endSpan(span, err)
 						continue
 					}
-					defer indsRows.Close()
 
 					// 遍历结果集，将结果封装成列表
 					var indicators []Indicator
 					for indsRows.Next() {
 						var code, name, value, valueExplain string
 						if err := indsRows.Scan(&code, &name, &value, &valueExplain); err != nil {
-							log.Printf("工作线程 %d 扫描指标结果失败: %v", workerID, err)
+							vlog.ErrorContext(vctx, "扫描指标结果失败", "error", err)
 							continue
 						}
 						indicators = append(indicators, Indicator{
@@ -178,14 +160,18 @@ func ProcessMZ(deletedFlag int) error {
 						})
 					}
 					// 打印指标个数
-					log.Printf("工作线程 %d 指标个数: %d", workerID, len(indicators))
-					
+					vlog.DebugContext(vctx, "指标个数", "count", len(indicators))
+
 					// 检查指标查询是否有错误
-					if err = indsRows.Err(); err != nil {
-						log.Printf("工作线程 %d 指标行迭代错误: %v", workerID, err)
+					err = indsRows.Err()
+					indsRows.Close()
+					if err != nil {
+						vlog.ErrorContext(vctx, "指标行迭代错误", "error", err)
+						endSpan(span, err)
 						continue
 					}
 
+					req := &mzRequest{db: db, visit: visit, fsjd: deletedFlag, storeDiscrepancies: storeDiscrepancies}
 					// 按50个一组处理指标
 					for i := 0; i < len(indicators); i += 50 {
 						end := i + 50
@@ -193,46 +179,26 @@ func ProcessMZ(deletedFlag int) error {
 							end = len(indicators)
 						}
 
-						// 将指标数组转换为JSON字符串
-						indicatorJSON, err := json.Marshal(indicators[i:end])
-						if err != nil {
-							log.Printf("工作线程 %d 指标JSON序列化失败: %v", workerID, err)
-							continue
-						}
-
-						// 调用Dify API，添加重试机制
-						var resultData []Indicator
-						maxRetries := 3
-						retryCount := 0
-						var apiErr error
-						success := false
-
-						for retryCount < maxRetries {
-							resultData, apiErr = RunWorkflowWithSDK_MZ(visit.Content, string(indicatorJSON))
-							if apiErr == nil {
-								success = true
-								break
-							}
-
-							retryCount++
-							log.Printf("工作线程 %d 调用Dify API失败 (encounter_id=%s, 重试 %d/%d): %v", 
-								workerID, visit.EncounterId, retryCount, maxRetries, apiErr)
-
-							// 只有在还有重试次数时才休眠
-							if retryCount < maxRetries {
-								time.Sleep(2 * time.Second)
-							}
-						}
-
-						if !success {
-							log.Printf("工作线程 %d 调用Dify API达到最大重试次数 (encounter_id=%s): %v", 
-								workerID, visit.EncounterId, apiErr)
+						// 每批指标一个span，覆盖Dify调用与结果入库
+						bctx, batchSpan := tracer.Start(vctx, "indicator batch", trace.WithAttributes(
+							attribute.Int("batch.offset", i), attribute.Int("batch.size", end-i)))
+
+						// 按MZ.RECONCILE对账，缺少的指标补充请求
+						resultData, apiErr := req.requestIndicators(bctx, indicators[i:end])
+						if apiErr != nil {
+							vlog.ErrorContext(vctx, "调用Dify API失败", "error", apiErr)
+							countPipeline(job, JobProcessMZ, "batches_failed", 1)
+							job.RecordError(fmt.Errorf("encounter_id=%s: %w", visit.EncounterId, apiErr))
+							endSpan(batchSpan, apiErr)
 							continue
 						}
 
 						// 处理返回结果
-						processIndicatorResults(ctx, workerDB, workerID, visit, resultData)
+						processIndicatorResults(bctx, db, workerID, visit, resultData)
+						batchSpan.End()
 					}
+					span.SetAttributes(attribute.Int("indicators", len(indicators)))
+					span.End()
 				}
 			}
 		}(i)
@@ -247,12 +213,18 @@ func ProcessMZ(deletedFlag int) error {
 	// 等待所有工作线程完成
 	wg.Wait()
 
-	log.Println("所有记录处理完毕")
+	if err := ctx.Err(); err != nil {
+		slog.WarnContext(ctx, "记录处理被中断", "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "所有记录处理完毕")
 	return nil
 }
 
 // processIndicatorResults 处理指标结果，执行数据库删除和插入操作
 func processIndicatorResults(ctx context.Context, db *sql.DB, workerID int, visit PatientVisitMZ, indicators []Indicator) {
+	job := JobFromContext(ctx)
+	logger := slog.With("worker", workerID, "encounter_id", visit.EncounterId)
 	// 初始化计数器
 	totalIndicators := len(indicators)
 	emptyIndicators := 0
@@ -267,25 +239,25 @@ func processIndicatorResults(ctx context.Context, db *sql.DB, workerID int, visi
 		// 删除已存在的数据
 		_, err := db.ExecContext(ctx, `
 			DELETE FROM public.t_patient_data 
-			WHERE p_id = $1 AND v_id = $2 AND field_id = $3;`, 
+			WHERE p_id = $1 AND v_id = $2 AND field_id = $3;`,
 			visit.PersonId, visit.PersonId, item.Code)
 		if err != nil {
-			log.Printf("工作线程 %d 删除数据失败 (encounter_id=%s): %v", workerID, visit.EncounterId, err)
+			logger.ErrorContext(ctx, "删除数据失败", "error", err)
 			continue
 		}
 
 		// 插入新数据
 		_, err = db.ExecContext(ctx, `
 			INSERT INTO public.t_patient_data (p_id, v_id, field_id, value, "source")
-			VALUES ($1, $2, $3, $4, 'dify');`, 
+			VALUES ($1, $2, $3, $4, 'dify');`,
 			visit.PersonId, visit.PersonId, item.Code, item.Value)
 		if err != nil {
-			log.Printf("工作线程 %d 插入数据失败 (encounter_id=%s): %v", workerID, visit.EncounterId, err)
+			logger.ErrorContext(ctx, "插入数据失败", "error", err)
+			continue
 		}
+		countPipeline(job, JobProcessMZ, "indicators_saved", 1)
 	}
 
 	// 输出统计信息
-	log.Printf("工作线程 %d 指标统计 (encounter_id=%s): 总指标数=%d, 空指标数=%d, 非空指标数=%d", 
-		workerID, visit.EncounterId, totalIndicators, emptyIndicators, totalIndicators-emptyIndicators)
+	logger.InfoContext(ctx, "指标统计", "total", totalIndicators, "empty", emptyIndicators, "non_empty", totalIndicators-emptyIndicators)
 }
-
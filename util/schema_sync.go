@@ -0,0 +1,545 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// mysqlColumn 源表列信息，来自information_schema.columns
+type mysqlColumn struct {
+	Name       string
+	DataType   string // 如varchar
+	ColumnType string // 如varchar(64)、int(10) unsigned
+	Nullable   bool
+	Precision  sql.NullInt64
+	Scale      sql.NullInt64
+	Length     sql.NullInt64
+	Default    sql.NullString
+	Extra      string // 如auto_increment、on update current_timestamp
+	Comment    string
+}
+
+// AutoIncrement 是否为自增列
+func (c mysqlColumn) AutoIncrement() bool {
+	return strings.Contains(c.Extra, "auto_increment")
+}
+
+// mysqlIndex 源表的非主键索引
+type mysqlIndex struct {
+	Name    string
+	Unique  bool
+	Type    string // BTREE / HASH / FULLTEXT / SPATIAL
+	Columns []string
+}
+
+// mysqlTable 源表结构
+type mysqlTable struct {
+	Name       string
+	Comment    string
+	Columns    []mysqlColumn
+	PrimaryKey []string
+	Indexes    []mysqlIndex
+}
+
+// TableDDL 单表的DDL及转换中无法对应的项
+type TableDDL struct {
+	Table      string   `json:"table"`
+	Target     string   `json:"target"`  // 按sync_tables规则映射的目标表
+	Created    bool     `json:"created"` // 目标表原不存在
+	Statements []string `json:"statements"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// SchemaSyncReport 表结构同步结果，dry-run时只生成DDL
+type SchemaSyncReport struct {
+	DryRun bool       `json:"dry_run"`
+	Schema string     `json:"schema"`
+	Tables []TableDDL `json:"tables"`
+}
+
+// Failed 生成或执行DDL失败的表
+func (r *SchemaSyncReport) Failed() map[string]bool {
+	failed := make(map[string]bool)
+	for _, t := range r.Tables {
+		if t.Error != "" {
+			failed[t.Table] = true
+		}
+	}
+	return failed
+}
+
+// SchemaSync 表结构同步任务，任务参数dry_run=true时只生成DDL，tables(逗号分隔)只同步指定的表，
+// 结果通过任务result返回
+func SchemaSync(ctx context.Context, srcDB *sql.DB) error {
+	job := JobFromContext(ctx)
+	tgtDB, err := DBs.Get(DBPostgresTgt)
+	if err != nil {
+		return err
+	}
+	src, err := DBs.Config(DBMySQLSrc)
+	if err != nil {
+		return err
+	}
+	tgt, err := DBs.Config(DBPostgresTgt)
+	if err != nil {
+		return err
+	}
+	report, err := SyncSchema(ctx, srcDB, tgtDB, src, tgt, splitTables(job.Param("tables")), job.Param("dry_run") == "true")
+	job.SetResult(report)
+	return err
+}
+
+// SyncSchema 读取mysql_src的表结构，生成Postgres DDL并在目标schema中执行，每张表一个事务。
+// 目标表不存在时创建表、索引与注释；已存在时只补充缺少的列和索引，不修改已有列。
+// 目标表名、schema与排除的列按sync_tables规则映射。
+// tables为空时按SYNC.INCLUDE、SYNC.EXCLUDE选择表；单表失败记录在结果中，不影响其他表
+func SyncSchema(ctx context.Context, srcDB, tgtDB *sql.DB, src, tgt DBConfig, tables []string, dryRun bool) (*SchemaSyncReport, error) {
+	job := JobFromContext(ctx)
+	report := &SchemaSyncReport{DryRun: dryRun, Schema: tgt.Schema, Tables: []TableDDL{}}
+	tables, err := selectSyncTables(ctx, srcDB, tables)
+	if err != nil {
+		return report, err
+	}
+	if !dryRun {
+		if _, err := tgtDB.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{tgt.Schema}.Sanitize()); err != nil {
+			return report, fmt.Errorf("创建schema失败: %w", err)
+		}
+	}
+
+	for _, table := range tables {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		ddl, err := syncTableSchema(ctx, srcDB, tgtDB, src.Database, tgt, table, dryRun)
+		if err != nil {
+			ddl.Error = err.Error()
+			slog.ErrorContext(ctx, "表结构同步失败", "table", table, "error", err)
+			job.Add("schema_failed", 1)
+			job.RecordError(fmt.Errorf("%s: %w", table, err))
+		} else if len(ddl.Statements) > 0 {
+			job.Add("schema_changed", 1)
+		}
+		for _, w := range ddl.Warnings {
+			slog.WarnContext(ctx, "表结构转换", "table", table, "warning", w)
+		}
+		report.Tables = append(report.Tables, ddl)
+	}
+	slog.InfoContext(ctx, "表结构同步完成", "tables", len(report.Tables), "dry_run", dryRun)
+	return report, nil
+}
+
+// CreateMissingTables 只对目标库中不存在的表执行表结构同步，已存在的表不检查、不修改。
+// 目标表名按sync_tables规则映射，规则无效的表交给SyncSchema记录失败
+func CreateMissingTables(ctx context.Context, srcDB, tgtDB *sql.DB, src, tgt DBConfig, tables []string) (*SchemaSyncReport, error) {
+	var missing []string
+	for _, table := range tables {
+		rule, err := syncTableRule(table, tgt)
+		if err != nil {
+			missing = append(missing, table)
+			continue
+		}
+		var exists bool
+		err = tgtDB.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL",
+			pgx.Identifier{rule.Schema, rule.Target}.Sanitize()).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("检查目标表%s.%s失败: %w", rule.Schema, rule.Target, err)
+		}
+		if !exists {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) == 0 {
+		return &SchemaSyncReport{Schema: tgt.Schema, Tables: []TableDDL{}}, nil
+	}
+	slog.InfoContext(ctx, "创建缺少的目标表", "tables", missing)
+	return SyncSchema(ctx, srcDB, tgtDB, src, tgt, missing, false)
+}
+
+// syncTableSchema 生成并执行单表DDL
+func syncTableSchema(ctx context.Context, srcDB, tgtDB *sql.DB, database string, tgt DBConfig, table string, dryRun bool) (TableDDL, error) {
+	ddl := TableDDL{Table: table, Statements: []string{}}
+	rule, err := syncTableRule(table, tgt)
+	if err != nil {
+		return ddl, err
+	}
+	ddl.Target = rule.Schema + "." + rule.Target
+	t, err := readMySQLTable(ctx, srcDB, database, table)
+	if err != nil {
+		return ddl, fmt.Errorf("读取表结构失败: %w", err)
+	}
+	if ddl.Warnings, err = t.applyRule(rule); err != nil {
+		return ddl, err
+	}
+	existing, err := queryStrings(ctx, tgtDB,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2", rule.Schema, rule.Target)
+	if err != nil {
+		return ddl, fmt.Errorf("读取目标表结构失败: %w", err)
+	}
+	ddl.Created = len(existing) == 0
+	stmts, warnings := t.pgDDL(rule.Schema, existing)
+	if ddl.Created && rule.Schema != tgt.Schema {
+		stmts = append([]string{"CREATE SCHEMA IF NOT EXISTS " + pgx.Identifier{rule.Schema}.Sanitize()}, stmts...)
+	}
+	ddl.Statements, ddl.Warnings = stmts, append(ddl.Warnings, warnings...)
+	if dryRun || len(ddl.Statements) == 0 {
+		return ddl, nil
+	}
+
+	tx, err := tgtDB.BeginTx(ctx, nil)
+	if err != nil {
+		return ddl, err
+	}
+	defer tx.Rollback()
+	for _, stmt := range ddl.Statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return ddl, fmt.Errorf("执行DDL失败: %w\n%s", err, stmt)
+		}
+	}
+	return ddl, tx.Commit()
+}
+
+// readMySQLTable 从information_schema读取表注释、列、主键与索引
+func readMySQLTable(ctx context.Context, db *sql.DB, database, table string) (*mysqlTable, error) {
+	t := &mysqlTable{Name: table}
+	err := db.QueryRowContext(ctx, "select table_comment from information_schema.tables where table_schema = ? and table_name = ?",
+		database, table).Scan(&t.Comment)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("表%s不存在", table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Columns, err = mysqlColumns(ctx, db, database, table); err != nil {
+		return nil, err
+	}
+	if len(t.Columns) == 0 {
+		return nil, fmt.Errorf("表%s没有列", table)
+	}
+	if t.PrimaryKey, err = mysqlPrimaryKey(ctx, db, database, table); err != nil {
+		return nil, err
+	}
+	if t.Indexes, err = mysqlIndexes(ctx, db, database, table); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// mysqlColumns 读取源表的列定义
+func mysqlColumns(ctx context.Context, db *sql.DB, database, table string) ([]mysqlColumn, error) {
+	rows, err := db.QueryContext(ctx, `
+		select column_name, data_type, column_type, is_nullable, numeric_precision, numeric_scale, character_maximum_length,
+			column_default, extra, column_comment
+		from information_schema.columns where table_schema = ? and table_name = ? order by ordinal_position`, database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []mysqlColumn
+	for rows.Next() {
+		var c mysqlColumn
+		var nullable string
+		if err := rows.Scan(&c.Name, &c.DataType, &c.ColumnType, &nullable, &c.Precision, &c.Scale, &c.Length,
+			&c.Default, &c.Extra, &c.Comment); err != nil {
+			return nil, err
+		}
+		c.DataType = strings.ToLower(c.DataType)
+		c.ColumnType = strings.ToLower(c.ColumnType)
+		c.Extra = strings.ToLower(c.Extra)
+		c.Nullable = nullable == "YES"
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+// mysqlPrimaryKey 读取源表主键列，按主键中的顺序
+func mysqlPrimaryKey(ctx context.Context, db *sql.DB, database, table string) ([]string, error) {
+	return queryStrings(ctx, db, `
+		select column_name from information_schema.key_column_usage
+		where table_schema = ? and table_name = ? and constraint_name = 'PRIMARY' order by ordinal_position`, database, table)
+}
+
+// mysqlIndexes 读取源表的非主键索引，函数索引(column_name为NULL)的列为空
+func mysqlIndexes(ctx context.Context, db *sql.DB, database, table string) ([]mysqlIndex, error) {
+	rows, err := db.QueryContext(ctx, `
+		select index_name, non_unique, index_type, column_name from information_schema.statistics
+		where table_schema = ? and table_name = ? and index_name <> 'PRIMARY' order by index_name, seq_in_index`, database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []mysqlIndex
+	for rows.Next() {
+		var name, indexType string
+		var nonUnique int
+		var column sql.NullString
+		if err := rows.Scan(&name, &nonUnique, &indexType, &column); err != nil {
+			return nil, err
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name {
+			indexes = append(indexes, mysqlIndex{Name: name, Unique: nonUnique == 0, Type: strings.ToUpper(indexType)})
+		}
+		idx := &indexes[len(indexes)-1]
+		if !column.Valid {
+			idx.Columns = nil
+			idx.Type = "FUNCTIONAL"
+			continue
+		}
+		if idx.Type != "FUNCTIONAL" {
+			idx.Columns = append(idx.Columns, column.String)
+		}
+	}
+	return indexes, rows.Err()
+}
+
+// pgColumnType MySQL列类型对应的Postgres类型。无符号整数升级为更大的类型；
+// TIME可超过24小时，对应interval；空间类型按原始字节保存
+func pgColumnType(c mysqlColumn) string {
+	unsigned := strings.Contains(c.ColumnType, "unsigned")
+	switch c.DataType {
+	case "tinyint", "year":
+		return "smallint"
+	case "smallint":
+		if unsigned {
+			return "integer"
+		}
+		return "smallint"
+	case "mediumint":
+		return "integer"
+	case "int", "integer":
+		if unsigned {
+			return "bigint"
+		}
+		return "integer"
+	case "bigint":
+		// 自增列对应identity，需为整数类型
+		if unsigned && !c.AutoIncrement() {
+			return "numeric(20)"
+		}
+		return "bigint"
+	case "decimal", "numeric":
+		if c.Precision.Valid {
+			return fmt.Sprintf("numeric(%d,%d)", c.Precision.Int64, c.Scale.Int64)
+		}
+		return "numeric"
+	case "float":
+		return "real"
+	case "double", "real":
+		return "double precision"
+	case "bit":
+		if c.Precision.Int64 == 1 {
+			return "boolean"
+		}
+		return "bigint"
+	case "char", "varchar":
+		if c.Length.Valid {
+			return fmt.Sprintf("varchar(%d)", c.Length.Int64)
+		}
+		return "text"
+	case "json":
+		return "jsonb"
+	case "date":
+		return "date"
+	case "datetime", "timestamp":
+		return "timestamp"
+	case "time":
+		return "interval"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		return "bytea"
+	default:
+		// text类、enum、set
+		return "text"
+	}
+}
+
+// applyRule 按同步规则改为目标表名并去掉排除的列，包含排除列的索引不再创建
+func (t *mysqlTable) applyRule(rule SyncTableRule) ([]string, error) {
+	cols, err := rule.Columns(t.Name, t.Columns, t.PrimaryKey)
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		kept[c.Name] = true
+	}
+	var warnings []string
+	indexes := t.Indexes[:0:0]
+	for _, idx := range t.Indexes {
+		ok := true
+		for _, c := range idx.Columns {
+			ok = ok && kept[c]
+		}
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("索引%s包含排除的列，未创建", idx.Name))
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+	t.Name, t.Columns, t.Indexes = rule.Target, cols, indexes
+	return warnings, nil
+}
+
+// pgDDL 生成目标schema中的DDL，existing为目标表已有的列，为空表示目标表不存在
+func (t *mysqlTable) pgDDL(schema string, existing []string) (stmts, warnings []string) {
+	target := pgx.Identifier{schema, t.Name}.Sanitize()
+
+	if len(existing) == 0 {
+		defs := make([]string, 0, len(t.Columns)+1)
+		for _, c := range t.Columns {
+			def, warn := pgColumnDef(c)
+			defs = append(defs, def)
+			warnings = append(warnings, warn...)
+		}
+		if len(t.PrimaryKey) > 0 {
+			defs = append(defs, "PRIMARY KEY ("+pgIdentList(t.PrimaryKey)+")")
+		} else {
+			warnings = append(warnings, "源表没有主键")
+		}
+		stmts = append(stmts, "CREATE TABLE IF NOT EXISTS "+target+" (\n\t"+strings.Join(defs, ",\n\t")+"\n)")
+		if t.Comment != "" {
+			stmts = append(stmts, "COMMENT ON TABLE "+target+" IS "+pgLiteral(t.Comment))
+		}
+		for _, c := range t.Columns {
+			if c.Comment != "" {
+				stmts = append(stmts, "COMMENT ON COLUMN "+target+"."+pgx.Identifier{c.Name}.Sanitize()+" IS "+pgLiteral(c.Comment))
+			}
+		}
+	} else {
+		for _, c := range t.Columns {
+			if contains(existing, c.Name) {
+				continue
+			}
+			def, warn := pgColumnDef(c)
+			// 已有数据的表新增NOT NULL且无默认值的列会失败，交由人工处理
+			stmts = append(stmts, "ALTER TABLE "+target+" ADD COLUMN IF NOT EXISTS "+def)
+			warnings = append(warnings, warn...)
+			if c.Comment != "" {
+				stmts = append(stmts, "COMMENT ON COLUMN "+target+"."+pgx.Identifier{c.Name}.Sanitize()+" IS "+pgLiteral(c.Comment))
+			}
+		}
+	}
+
+	for _, idx := range t.Indexes {
+		if idx.Type == "FULLTEXT" || idx.Type == "SPATIAL" || idx.Type == "FUNCTIONAL" || len(idx.Columns) == 0 {
+			warnings = append(warnings, fmt.Sprintf("索引%s类型为%s，未创建", idx.Name, idx.Type))
+			continue
+		}
+		stmt := "CREATE INDEX IF NOT EXISTS "
+		if idx.Unique {
+			stmt = "CREATE UNIQUE INDEX IF NOT EXISTS "
+		}
+		// Postgres索引名在schema内唯一，加表名前缀
+		stmt += pgx.Identifier{pgIndexName(t.Name, idx.Name)}.Sanitize() + " ON " + target + " (" + pgIdentList(idx.Columns) + ")"
+		stmts = append(stmts, stmt)
+	}
+	return stmts, warnings
+}
+
+// pgColumnDef 列定义：类型、自增对应的identity、默认值与非空约束
+func pgColumnDef(c mysqlColumn) (string, []string) {
+	var warnings []string
+	pgType := pgColumnType(c)
+	def := pgx.Identifier{c.Name}.Sanitize() + " " + pgType
+	if c.AutoIncrement() {
+		// BY DEFAULT允许同步时写入源表的id
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	} else if d, ok := pgDefault(c, pgType); ok {
+		def += " DEFAULT " + d
+	} else if c.Default.Valid && strings.ToUpper(c.Default.String) != "NULL" {
+		warnings = append(warnings, fmt.Sprintf("列%s的默认值%q无法转换", c.Name, c.Default.String))
+	}
+	if strings.Contains(c.Extra, "on update") {
+		warnings = append(warnings, fmt.Sprintf("列%s的ON UPDATE CURRENT_TIMESTAMP在Postgres中需用触发器实现", c.Name))
+	}
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	return def, warnings
+}
+
+// pgDefault 转换列默认值。MySQL 8的information_schema中字符串默认值不带引号，MariaDB带引号；
+// CURRENT_TIMESTAMP以外的表达式默认值不转换
+func pgDefault(c mysqlColumn, pgType string) (string, bool) {
+	if !c.Default.Valid {
+		return "", false
+	}
+	d := c.Default.String
+	upper := strings.ToUpper(d)
+	switch {
+	case upper == "NULL":
+		return "", false
+	case strings.HasPrefix(upper, "CURRENT_TIMESTAMP"), strings.HasPrefix(upper, "NOW("), strings.HasPrefix(upper, "LOCALTIMESTAMP"):
+		if pgType == "date" {
+			return "CURRENT_DATE", true
+		}
+		return "CURRENT_TIMESTAMP", true
+	case strings.Contains(c.Extra, "default_generated"):
+		return "", false
+	}
+	if len(d) >= 2 && d[0] == '\'' && d[len(d)-1] == '\'' {
+		d = strings.ReplaceAll(d[1:len(d)-1], "''", "'")
+	}
+
+	switch {
+	case pgType == "boolean":
+		d = strings.TrimSuffix(strings.TrimPrefix(d, "b'"), "'")
+		n, err := strconv.ParseUint(d, 2, 64)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatBool(n != 0), true
+	case c.DataType == "bit":
+		n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(d, "b'"), "'"), 2, 64)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatUint(n, 10), true
+	case pgType == "smallint", pgType == "integer", pgType == "bigint", pgType == "real", pgType == "double precision",
+		strings.HasPrefix(pgType, "numeric"):
+		if _, err := strconv.ParseFloat(d, 64); err != nil {
+			return "", false
+		}
+		return d, true
+	case pgType == "date", pgType == "timestamp":
+		if strings.HasPrefix(d, "0000-00-00") {
+			return "", false
+		}
+		return pgLiteral(d), true
+	case pgType == "bytea", pgType == "jsonb":
+		return "", false
+	default:
+		return pgLiteral(d), true
+	}
+}
+
+// pgLiteral Postgres字符串字面量
+func pgLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// pgIdentList 引用后以逗号连接的列名
+func pgIdentList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pgx.Identifier{name}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// pgIndexName 表名前缀的索引名，超过63字节时截断
+func pgIndexName(table, index string) string {
+	name := table + "_" + index
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
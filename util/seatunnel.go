@@ -0,0 +1,211 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// defaultSeatunnelTemplate 内置的单表同步模板，SEATUNNEL.TEMPLATE未配置时使用
+//
+//go:embed templates/seatunnel_mysql_pg.conf.tmpl
+var defaultSeatunnelTemplate string
+
+// 通过环境变量传给seatunnel.sh的密码，配置文件中以${VAR}引用，由HOCON解析时从环境变量替换
+const (
+	seatunnelSourcePasswordEnv = "ST_SOURCE_PASSWORD"
+	seatunnelSinkPasswordEnv   = "ST_SINK_PASSWORD"
+)
+
+// seatunnelConn 模板中的源或目标连接，Password为已渲染的HOCON值
+type seatunnelConn struct {
+	URL      string
+	Driver   string
+	User     string
+	Password string
+	Database string
+	Table    string
+}
+
+// seatunnelJob 单表同步的模板数据
+type seatunnelJob struct {
+	Table           string
+	Query           string
+	Parallelism     int
+	ChunkSize       int
+	PartitionColumn string
+	Source          seatunnelConn
+	Sink            seatunnelConn
+}
+
+// hoconString 输出HOCON带引号字符串，语法与JSON字符串相同，引号内的${}不做替换
+func hoconString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// seatunnelPassword 启用SEATUNNEL.ENV_CREDENTIALS时密码只以环境变量引用写入配置文件，
+// 否则写入转义后的字符串；返回HOCON值与需要传给子进程的环境变量
+func seatunnelPassword(envName, password string) (string, []string) {
+	if viper.GetBool("SEATUNNEL.ENV_CREDENTIALS") {
+		return "${" + envName + "}", []string{envName + "=" + password}
+	}
+	return hoconString(password), nil
+}
+
+// seatunnelTemplate 解析SEATUNNEL.TEMPLATE指定的模板，未配置时使用内置模板
+func seatunnelTemplate() (*template.Template, error) {
+	text, name := defaultSeatunnelTemplate, "seatunnel_mysql_pg.conf.tmpl"
+	if path := viper.GetString("SEATUNNEL.TEMPLATE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取Seatunnel模板失败: %w", err)
+		}
+		text, name = string(b), path
+	}
+	tmpl, err := template.New(name).Funcs(template.FuncMap{"hocon": hoconString}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("解析Seatunnel模板失败: %w", err)
+	}
+	return tmpl, nil
+}
+
+// unsafeFileChars 不适合出现在文件名中的字符
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// generateConfigFile 渲染模板，写入SEATUNNEL.JOB_DIR下权限为0600的临时文件并返回路径；
+// 出错时文件已删除，成功时由调用方删除
+func generateConfigFile(job seatunnelJob) (string, error) {
+	tmpl, err := seatunnelTemplate()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, job); err != nil {
+		return "", fmt.Errorf("渲染Seatunnel模板失败: %w", err)
+	}
+
+	dir := viper.GetString("SEATUNNEL.JOB_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// CreateTemp创建的文件权限为0600
+	f, err := os.CreateTemp(dir, "pg_sync_"+unsafeFileChars.ReplaceAllString(job.Table, "_")+"_*.conf")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// seatunnelArgs seatunnel.sh参数，SEATUNNEL.MASTER为local或cluster(Zeta引擎集群地址见其hazelcast-client.yaml)，
+// Spark/Flink启动脚本可填对应的master
+func seatunnelArgs(configPath string) []string {
+	args := []string{"--config", configPath, "-m", viper.GetString("SEATUNNEL.MASTER")}
+	if name := viper.GetString("SEATUNNEL.CLUSTER_NAME"); name != "" {
+		args = append(args, "-cn", name)
+	}
+	return append(args, viper.GetStringSlice("SEATUNNEL.ARGS")...)
+}
+
+// syncTableWithSeatunnel 按同步规则生成单表配置并执行Seatunnel，返回作业执行结果
+func syncTableWithSeatunnel(ctx context.Context, srcDB *sql.DB, src, tgt DBConfig, table string) (*SeatunnelResult, error) {
+	rule, err := syncTableRule(table, tgt)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := mysqlColumns(ctx, srcDB, src.Database, table)
+	if err != nil {
+		return nil, fmt.Errorf("读取表结构失败: %w", err)
+	}
+	pk, err := mysqlPrimaryKey(ctx, srcDB, src.Database, table)
+	if err != nil {
+		return nil, fmt.Errorf("读取主键失败: %w", err)
+	}
+	if cols, err = rule.Columns(table, cols, pk); err != nil {
+		return nil, err
+	}
+
+	selectCols := make([]string, len(cols))
+	var partitionColumn string
+	for i, c := range cols {
+		selectCols[i] = quoteMySQL(c.Name)
+		if rule.Parallelism > 1 && len(pk) == 1 && c.Name == pk[0] && watermarkIsInt(c) {
+			partitionColumn = c.Name
+		}
+	}
+	query := "select " + strings.Join(selectCols, ", ") + " from " + quoteMySQL(src.Database) + "." + quoteMySQL(table)
+	if rule.Where != "" {
+		query += " where " + rule.Where
+	}
+
+	srcPassword, env := seatunnelPassword(seatunnelSourcePasswordEnv, src.Password)
+	tgtPassword, tgtEnv := seatunnelPassword(seatunnelSinkPasswordEnv, tgt.Password)
+	configPath, err := generateConfigFile(seatunnelJob{
+		Table:           table,
+		Query:           query,
+		Parallelism:     rule.Parallelism,
+		ChunkSize:       rule.ChunkSize,
+		PartitionColumn: partitionColumn,
+		Source: seatunnelConn{
+			URL: src.MySQLJDBCURL(), Driver: "com.mysql.cj.jdbc.Driver", User: src.User, Password: srcPassword,
+			Database: src.Database, Table: src.Database + "." + table,
+		},
+		Sink: seatunnelConn{
+			URL: tgt.PostgresJDBCURL(), Driver: "org.postgresql.Driver", User: tgt.User, Password: tgtPassword,
+			Database: tgt.Database, Table: rule.Schema + "." + rule.Target,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("生成配置失败: %w", err)
+	}
+	defer os.Remove(configPath)
+
+	result, err := executeSeatunnel(ctx, table, configPath, append(env, tgtEnv...))
+	if result != nil {
+		job := JobFromContext(ctx)
+		countPipeline(job, JobSeatunnelMysqlPg, "rows_copied", result.WriteCount)
+		countPipeline(job, JobSeatunnelMysqlPg, "rows_failed", result.FailedCount)
+	}
+	return result, err
+}
+
+// seatunnelSyncer 为每张表渲染HOCON配置并调用SEATUNNEL.COMMAND，需要JVM与Seatunnel安装目录
+type seatunnelSyncer struct {
+	srcDB    *sql.DB
+	src, tgt DBConfig
+}
+
+func (s seatunnelSyncer) Name() string { return SyncBackendSeatunnel }
+
+func (s seatunnelSyncer) SyncTable(ctx context.Context, table string) error {
+	_, err := s.SeatunnelSyncTable(ctx, table)
+	return err
+}
+
+// SeatunnelSyncTable 同步单表并返回Seatunnel作业结果，结果随同步任务状态返回
+func (s seatunnelSyncer) SeatunnelSyncTable(ctx context.Context, table string) (*SeatunnelResult, error) {
+	return syncTableWithSeatunnel(ctx, s.srcDB, s.src, s.tgt, table)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package util
+
+import (
+	"os/exec"
+	"time"
+)
+
+// setProcessGroup 非unix系统没有进程组，取消时只结束seatunnel.sh进程
+func setProcessGroup(cmd *exec.Cmd, grace time.Duration) {}
@@ -0,0 +1,180 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// SeatunnelResult 单表Seatunnel作业的执行结果，读写统计取自作业结束时输出的Job Statistic Information
+type SeatunnelResult struct {
+	LogFile        string  `json:"log_file"`
+	ExitCode       int     `json:"exit_code"`
+	TimedOut       bool    `json:"timed_out,omitempty"`
+	StatsParsed    bool    `json:"stats_parsed"`
+	StartTime      string  `json:"start_time,omitempty"`
+	EndTime        string  `json:"end_time,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	ReadCount      int64   `json:"read_count"`
+	WriteCount     int64   `json:"write_count"`
+	FailedCount    int64   `json:"failed_count"`
+}
+
+// seatunnelStatLine Seatunnel作业统计行，如"Total Read Count          :                 100"。
+// 统计块的第一行带日志前缀，因此不按行首匹配
+var seatunnelStatLine = regexp.MustCompile(`(Start Time|End Time|Total Time\(s\)|Total Read Count|Total Write Count|Total Failed Count)\s*:\s*(\S.*?)\s*$`)
+
+// seatunnelTailLines 失败时写入日志的输出末尾行数
+const seatunnelTailLines = 20
+
+// seatunnelOutput 将Seatunnel的stdout、stderr写入日志文件，同时按行解析作业统计并保留末尾若干行。
+// stdout与stderr使用同一个*seatunnelOutput时，exec保证Write不会并发调用
+type seatunnelOutput struct {
+	w       io.Writer
+	partial []byte
+	tail    []string
+	result  *SeatunnelResult
+}
+
+func (o *seatunnelOutput) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	o.partial = append(o.partial, p...)
+	for {
+		i := bytes.IndexByte(o.partial, '\n')
+		if i < 0 {
+			break
+		}
+		o.line(string(bytes.TrimRight(o.partial[:i], "\r")))
+		o.partial = o.partial[i+1:]
+	}
+	return n, err
+}
+
+// flush 处理没有换行结尾的最后一行
+func (o *seatunnelOutput) flush() {
+	if len(o.partial) > 0 {
+		o.line(string(o.partial))
+		o.partial = nil
+	}
+}
+
+func (o *seatunnelOutput) line(s string) {
+	if len(o.tail) == seatunnelTailLines {
+		o.tail = o.tail[1:]
+	}
+	o.tail = append(o.tail, s)
+
+	m := seatunnelStatLine.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	r, value := o.result, m[2]
+	switch m[1] {
+	case "Start Time":
+		r.StartTime = value
+	case "End Time":
+		r.EndTime = value
+	case "Total Time(s)":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			r.ElapsedSeconds = v
+		}
+	default:
+		v, err := strconv.ParseInt(strings.ReplaceAll(value, ",", ""), 10, 64)
+		if err != nil {
+			return
+		}
+		switch m[1] {
+		case "Total Read Count":
+			r.ReadCount = v
+		case "Total Write Count":
+			r.WriteCount = v
+		case "Total Failed Count":
+			r.FailedCount = v
+		}
+		r.StatsParsed = true
+	}
+}
+
+// seatunnelLogPath 单表作业日志路径SEATUNNEL.LOG_DIR/<任务ID>_<表名>.log，命令行执行没有任务ID时使用时间戳
+func seatunnelLogPath(ctx context.Context, table string) (string, error) {
+	dir := viper.GetString("SEATUNNEL.LOG_DIR")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("创建Seatunnel日志目录失败: %w", err)
+	}
+	id := time.Now().Format("20060102150405")
+	if job := JobFromContext(ctx); job != nil {
+		id = job.ID
+	}
+	return filepath.Join(dir, id+"_"+unsafeFileChars.ReplaceAllString(table, "_")+".log"), nil
+}
+
+// executeSeatunnel 执行Seatunnel命令，env为额外的环境变量。输出写入单表日志文件并解析作业统计；
+// 超过SEATUNNEL.TABLE_TIMEOUT或ctx取消时终止整个进程组，SEATUNNEL.KILL_GRACE后仍未退出则强制结束
+func executeSeatunnel(ctx context.Context, table, configPath string, env []string) (*SeatunnelResult, error) {
+	logPath, err := seatunnelLogPath(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	// 未启用SEATUNNEL.ENV_CREDENTIALS时输出的作业配置中含有密码
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("创建Seatunnel日志文件失败: %w", err)
+	}
+	defer f.Close()
+
+	runCtx := ctx
+	timeout := viper.GetDuration("SEATUNNEL.TABLE_TIMEOUT")
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result := &SeatunnelResult{LogFile: logPath}
+	out := &seatunnelOutput{w: f, result: result}
+	cmd := exec.CommandContext(runCtx, viper.GetString("SEATUNNEL.COMMAND"), seatunnelArgs(configPath)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	grace := viper.GetDuration("SEATUNNEL.KILL_GRACE")
+	cmd.WaitDelay = grace
+	setProcessGroup(cmd, grace)
+
+	start := time.Now()
+	err = cmd.Run()
+	out.flush()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if !result.StatsParsed {
+		result.ElapsedSeconds = time.Since(start).Seconds()
+	}
+
+	if err != nil {
+		result.TimedOut = errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		slog.ErrorContext(ctx, "Seatunnel执行失败", "table", table, "log", logPath,
+			"timed_out", result.TimedOut, "output", strings.Join(out.tail, "\n"))
+		if result.TimedOut {
+			return result, fmt.Errorf("Seatunnel执行超过%s，已终止，日志: %s", timeout, logPath)
+		}
+		return result, fmt.Errorf("Seatunnel执行失败: %w，日志: %s", err, logPath)
+	}
+	if !result.StatsParsed {
+		slog.WarnContext(ctx, "Seatunnel输出中没有作业统计", "table", table, "log", logPath)
+	} else if result.FailedCount > 0 {
+		return result, fmt.Errorf("Seatunnel写入失败%d行，日志: %s", result.FailedCount, logPath)
+	}
+	return result, nil
+}
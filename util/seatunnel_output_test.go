@@ -0,0 +1,98 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// seatunnelStats Seatunnel 2.3作业结束时输出的统计块，第一行带日志前缀
+const seatunnelStats = "2025-09-01 10:00:05,123 INFO  [s.c.s.c.c.ClientExecuteCommand] [main] - \n" +
+	"***********************************************\n" +
+	"           Job Statistic Information\n" +
+	"***********************************************\n" +
+	"Start Time                : 2025-09-01 10:00:00\n" +
+	"End Time                  : 2025-09-01 10:00:05\n" +
+	"Total Time(s)             :                   5\n" +
+	"Total Read Count          :                1000\n" +
+	"Total Write Count         :                1000\n" +
+	"Total Failed Count        :                   0\n" +
+	"***********************************************\n"
+
+func TestSeatunnelOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   SeatunnelResult
+	}{
+		{
+			name:   "统计块",
+			output: "Job submitted\n" + seatunnelStats,
+			want: SeatunnelResult{StatsParsed: true, StartTime: "2025-09-01 10:00:00", EndTime: "2025-09-01 10:00:05",
+				ElapsedSeconds: 5, ReadCount: 1000, WriteCount: 1000},
+		},
+		{
+			name: "统计行带日志前缀",
+			output: "2025-09-01 10:00:05,123 INFO  [main] - Start Time                : 2025-09-01 10:00:00\n" +
+				"Total Read Count          :                  12\n",
+			want: SeatunnelResult{StatsParsed: true, StartTime: "2025-09-01 10:00:00", ReadCount: 12},
+		},
+		{
+			name: "逗号分隔的数字",
+			output: "Total Read Count          :           1,234,567\n" +
+				"Total Write Count         :           1,234,560\n" +
+				"Total Failed Count        :                   7\n",
+			want: SeatunnelResult{StatsParsed: true, ReadCount: 1234567, WriteCount: 1234560, FailedCount: 7},
+		},
+		{
+			name:   "Windows换行",
+			output: strings.ReplaceAll(seatunnelStats, "\n", "\r\n"),
+			want: SeatunnelResult{StatsParsed: true, StartTime: "2025-09-01 10:00:00", EndTime: "2025-09-01 10:00:05",
+				ElapsedSeconds: 5, ReadCount: 1000, WriteCount: 1000},
+		},
+		{
+			name:   "最后一行没有换行",
+			output: "Total Read Count          :                  10\nTotal Write Count         :                  9",
+			want:   SeatunnelResult{StatsParsed: true, ReadCount: 10, WriteCount: 9},
+		},
+		{
+			name:   "没有统计",
+			output: "Exception in thread \"main\" java.lang.RuntimeException: Total Read Count unknown\n\tat org.apache.seatunnel\n",
+			want:   SeatunnelResult{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 不同的分块方式模拟管道中任意位置截断的输出
+			for _, size := range []int{1, 3, 7, 64, len(tt.output)} {
+				var log bytes.Buffer
+				result := &SeatunnelResult{}
+				out := &seatunnelOutput{w: &log, result: result}
+				for rest := tt.output; rest != ""; {
+					n := min(size, len(rest))
+					if _, err := out.Write([]byte(rest[:n])); err != nil {
+						t.Fatal(err)
+					}
+					rest = rest[n:]
+				}
+				out.flush()
+				if *result != tt.want {
+					t.Errorf("分块%d: result = %+v, want %+v", size, *result, tt.want)
+				}
+				if log.String() != tt.output {
+					t.Errorf("分块%d: 日志文件内容与输出不一致", size)
+				}
+			}
+		})
+	}
+}
+
+func TestSeatunnelOutputTail(t *testing.T) {
+	out := &seatunnelOutput{w: &bytes.Buffer{}, result: &SeatunnelResult{}}
+	for i := 0; i < 30; i++ {
+		out.Write([]byte(strings.Repeat("x", i) + "\r\n"))
+	}
+	if len(out.tail) != seatunnelTailLines || out.tail[0] != strings.Repeat("x", 10) || out.tail[19] != strings.Repeat("x", 29) {
+		t.Errorf("tail = %q", out.tail)
+	}
+}
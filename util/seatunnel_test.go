@@ -0,0 +1,51 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestHoconString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"secret", `"secret"`},
+		{`pa"ss`, `"pa\"ss"`},
+		{`C:\pass\n`, `"C:\\pass\\n"`},
+		{"p${HOME}w", `"p${HOME}w"`},
+		{"${DB_PASSWORD}", `"${DB_PASSWORD}"`},
+		{"a<b>&c", `"a<b>&c"`},
+		{"line\nbreak\t", `"line\nbreak\t"`},
+		{"密码#1 // x", `"密码#1 // x"`},
+	}
+	for _, tt := range tests {
+		got := hoconString(tt.in)
+		if got != tt.want {
+			t.Errorf("hoconString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+		// HOCON带引号字符串与JSON字符串语法相同，解析后应还原为原密码
+		var back string
+		if err := json.Unmarshal([]byte(got), &back); err != nil || back != tt.in {
+			t.Errorf("hoconString(%q) = %s 无法还原: %q %v", tt.in, got, back, err)
+		}
+	}
+}
+
+func TestSeatunnelPassword(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("SEATUNNEL.ENV_CREDENTIALS", true)
+	value, env := seatunnelPassword("ST_SRC_PASSWORD", `p"a${x}`)
+	if value != "${ST_SRC_PASSWORD}" || len(env) != 1 || env[0] != `ST_SRC_PASSWORD=p"a${x}` {
+		t.Errorf("ENV_CREDENTIALS=true: %s %q", value, env)
+	}
+
+	viper.Set("SEATUNNEL.ENV_CREDENTIALS", false)
+	value, env = seatunnelPassword("ST_SRC_PASSWORD", `p"a${x}`)
+	if value != `"p\"a${x}"` || env != nil {
+		t.Errorf("ENV_CREDENTIALS=false: %s %q", value, env)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package util
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup seatunnel.sh会再启动JVM，子进程放入独立进程组，取消时向整个进程组发送SIGTERM，
+// grace后仍未退出则发送SIGKILL
+func setProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+			return err
+		}
+		time.AfterFunc(grace, func() { _ = syscall.Kill(-pgid, syscall.SIGKILL) })
+		return nil
+	}
+}
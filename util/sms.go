@@ -1,36 +1,76 @@
 package util
 
 import (
+	"context"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // CallSmsPlatform结构体用于封装短信平台相关信息及操作
 type CallSmsPlatform struct {
 	URL        string
 	SOAPAction string
+	Group      string // 接收人分组，仅用于指标统计
 }
 
 // SendSms方法用于向指定手机号发送短信内容
-func (c *CallSmsPlatform) SendSms(phone, content string) bool {
-	 // 如果当前日期超过就不发送短信
-    cutoffDate := viper.GetString("SEND_REAL_SMS_END_DAY")
-    currentDate := time.Now().Format("2006-01-02")
-    if currentDate > cutoffDate {
-        log.Println("SMS to:", phone)
-		log.Println("SMS content:", content)
-        return true
-    }
+func (c *CallSmsPlatform) SendSms(ctx context.Context, phone, content string) bool {
+	group := c.Group
+	if group == "" {
+		group = "default"
+	}
+	channel := "soap"
+	if !c.sendReal() {
+		channel = "log"
+	}
+
+	ctx, span := tracer.Start(ctx, "sms send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("sms.channel", channel), attribute.String("sms.group", group)))
+	defer span.End()
+
+	start := time.Now()
+	ok := c.send(ctx, phone, content)
+	result := "sent"
+	if !ok {
+		result = "failed"
+		span.SetStatus(codes.Error, "短信发送失败")
+	}
+	smsTotal.WithLabelValues(channel, group, result).Inc()
+	slog.InfoContext(ctx, "短信发送结果", "phone", phone, "channel", channel, "group", group, "result", result,
+		"duration", time.Since(start))
+	if channel == "soap" {
+		smsGatewayDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}
+	return ok
+}
+
+// sendReal 是否调用短信网关发送真实短信
+func (c *CallSmsPlatform) sendReal() bool {
+	cutoffDate := viper.GetString("SEND_REAL_SMS_END_DAY")
+	currentDate := time.Now().Format("2006-01-02")
+	return currentDate <= cutoffDate && viper.GetBool("SEND_REAL_SMS")
+}
+
+func (c *CallSmsPlatform) send(ctx context.Context, phone, content string) bool {
+	// 如果当前日期超过就不发送短信
+	cutoffDate := viper.GetString("SEND_REAL_SMS_END_DAY")
+	currentDate := time.Now().Format("2006-01-02")
+	if currentDate > cutoffDate {
+		slog.InfoContext(ctx, "未发送真实短信，仅记录日志", "phone", phone, "content", Redact(content))
+		return true
+	}
 
 	if !viper.GetBool("SEND_REAL_SMS") {
 		// 如果配置文件中SEND_REAL_SMS为false，则不发送真实短信
-		log.Println("SMS to:", phone)
-		log.Println("SMS content:", content)
+		slog.InfoContext(ctx, "未发送真实短信，仅记录日志", "phone", phone, "content", Redact(content))
 		return true
 	}
 	// 设置请求头
@@ -54,16 +94,13 @@ func (c *CallSmsPlatform) SendSms(phone, content string) bool {
 	// 创建一个HTTP客户端
 	client := &http.Client{}
 
-	log.Println("SOAPAction: ", c.SOAPAction)
-	log.Println("URL: ", c.URL)
-
-	log.Println("Sending SMS to", phone)
-	log.Println("Content:", content)
-	log.Println("SOAP Request:", soapRequest)
+	slog.DebugContext(ctx, "发送短信", "url", c.URL, "soap_action", c.SOAPAction, "phone", phone,
+		"content", Redact(content), "soap_request", Redact(soapRequest))
 
 	// 创建HTTP POST请求
-	req, err := http.NewRequest("POST", c.URL, strings.NewReader(soapRequest))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, strings.NewReader(soapRequest))
 	if err != nil {
+		slog.ErrorContext(ctx, "创建短信请求失败", "error", err)
 		return false
 	}
 
@@ -75,6 +112,7 @@ func (c *CallSmsPlatform) SendSms(phone, content string) bool {
 	// 发送请求
 	resp, err := client.Do(req)
 	if err != nil {
+		slog.ErrorContext(ctx, "调用短信网关失败", "phone", phone, "error", err)
 		return false
 	}
 	defer resp.Body.Close()
@@ -89,5 +127,30 @@ func (c *CallSmsPlatform) SendSms(phone, content string) bool {
 	if resp.StatusCode == 200 {
 		return true
 	}
+	slog.ErrorContext(ctx, "短信网关返回异常状态码", "phone", phone, "status", resp.StatusCode)
 	return false
 }
+
+// NotifyPhones 向PHONE_NUMBERS逐个发送短信，间隔SMS_SEND_INTERVAL秒，返回发送成功的号码数。
+// Prometheus告警与DolphinScheduler失败告警共用
+func NotifyPhones(ctx context.Context, group, message string) int {
+	platform := CallSmsPlatform{
+		URL:        viper.GetString("SMS_PLATFORM_URL"),
+		SOAPAction: viper.GetString("SOAP_ACTION"),
+		Group:      group,
+	}
+	interval := time.Duration(viper.GetInt("SMS_SEND_INTERVAL")) * time.Second
+
+	sent := 0
+	for i, phone := range viper.GetStringSlice("PHONE_NUMBERS") {
+		if i > 0 && sleepContext(ctx, interval) != nil {
+			break
+		}
+		if platform.SendSms(ctx, phone, message) {
+			sent++
+		} else {
+			slog.ErrorContext(ctx, "Failed to send SMS", "phone", phone)
+		}
+	}
+	return sent
+}
@@ -0,0 +1,221 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
+)
+
+// 增量同步检查点，写入postgres_tgt库，与目标表数据在同一事务中更新
+const createSyncCheckpointSQL = `
+CREATE TABLE IF NOT EXISTS public.sync_checkpoints (
+	target_table     varchar(256) PRIMARY KEY,
+	source_database  varchar(128) NOT NULL,
+	watermark_column varchar(128) NOT NULL,
+	watermark        text NOT NULL,
+	last_rows        bigint NOT NULL DEFAULT 0,
+	updated_at       timestamptz NOT NULL
+);`
+
+// syncCheckpoint 单表的同步检查点，Value为已同步到的水位列最大值
+type syncCheckpoint struct {
+	SourceDatabase string
+	Column         string
+	Value          string
+	Rows           int64
+}
+
+// loadSyncCheckpoint 读取目标表的检查点，不存在时返回空值
+func loadSyncCheckpoint(ctx context.Context, tx pgx.Tx, target string) (syncCheckpoint, error) {
+	var cp syncCheckpoint
+	err := tx.QueryRow(ctx, `SELECT source_database, watermark_column, watermark, last_rows
+		FROM public.sync_checkpoints WHERE target_table = $1`, target).
+		Scan(&cp.SourceDatabase, &cp.Column, &cp.Value, &cp.Rows)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return syncCheckpoint{}, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("读取同步检查点失败: %w", err)
+	}
+	return cp, nil
+}
+
+// saveSyncCheckpoint 更新目标表的检查点
+func saveSyncCheckpoint(ctx context.Context, tx pgx.Tx, target string, cp syncCheckpoint) error {
+	_, err := tx.Exec(ctx, `INSERT INTO public.sync_checkpoints
+			(target_table, source_database, watermark_column, watermark, last_rows, updated_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT (target_table) DO UPDATE SET source_database = EXCLUDED.source_database,
+			watermark_column = EXCLUDED.watermark_column, watermark = EXCLUDED.watermark,
+			last_rows = EXCLUDED.last_rows, updated_at = now()`,
+		target, cp.SourceDatabase, cp.Column, cp.Value, cp.Rows)
+	if err != nil {
+		return fmt.Errorf("更新同步检查点失败: %w", err)
+	}
+	return nil
+}
+
+// syncWatermark 选择表的增量水位列：同步规则中配置的列优先，其次为SYNC.WATERMARK_COLUMNS中
+// 第一个存在的列，最后为单列自增主键。upsert依赖主键，没有主键的表返回false，只能全量同步
+func syncWatermark(table, configured string, cols []mysqlColumn, pk []string) (mysqlColumn, bool, error) {
+	if len(pk) == 0 {
+		return mysqlColumn{}, false, nil
+	}
+	byName := make(map[string]mysqlColumn, len(cols))
+	for _, c := range cols {
+		byName[strings.ToLower(c.Name)] = c
+	}
+
+	if configured != "" {
+		c, ok := byName[strings.ToLower(configured)]
+		if !ok || !watermarkType(c) {
+			return mysqlColumn{}, false, fmt.Errorf("表%s的水位列%s不存在或类型不支持", table, configured)
+		}
+		return c, true, nil
+	}
+	for _, name := range viper.GetStringSlice("SYNC.WATERMARK_COLUMNS") {
+		if c, ok := byName[strings.ToLower(name)]; ok && watermarkType(c) {
+			return c, true, nil
+		}
+	}
+	if len(pk) == 1 {
+		if c := byName[strings.ToLower(pk[0])]; c.AutoIncrement() {
+			return c, true, nil
+		}
+	}
+	return mysqlColumn{}, false, nil
+}
+
+// watermarkType 水位列只支持时间和整数类型
+func watermarkType(c mysqlColumn) bool {
+	return watermarkIsTime(c) || watermarkIsInt(c)
+}
+
+func watermarkIsTime(c mysqlColumn) bool {
+	switch c.DataType {
+	case "datetime", "timestamp", "date":
+		return true
+	}
+	return false
+}
+
+func watermarkIsInt(c mysqlColumn) bool {
+	switch c.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// upsertSince 增量同步：读取水位列不小于检查点的行写入临时表，再按主键upsert到目标表，
+// 返回写入行数与新的水位。水位为NULL的行不会被增量读取，源表的删除也不会同步，需定期全量同步
+func (s *nativeSyncer) upsertSince(ctx context.Context, tx pgx.Tx, t nativeTable, target string,
+	wm mysqlColumn, from string) (int64, string, error) {
+	cols, pk := t.cols, t.pk
+	const stage = "sync_stage"
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE "+stage+" (LIKE "+target+") ON COMMIT DROP"); err != nil {
+		return 0, "", fmt.Errorf("创建临时表失败: %w", err)
+	}
+
+	// 按(水位列, 主键)分页，水位相同的行不会在分块边界丢失
+	keys := []string{wm.Name}
+	for _, k := range pk {
+		if k != wm.Name {
+			keys = append(keys, k)
+		}
+	}
+	n, last, err := s.copyRows(ctx, tx, t, stage, keys,
+		[]syncFilter{t.filter.and(quoteMySQL(wm.Name)+" >= ?", lagWatermark(wm, from))})
+	if err != nil {
+		return 0, "", err
+	}
+	if n == 0 {
+		return 0, from, nil
+	}
+
+	names := make([]string, len(cols))
+	var updates []string
+	isPK := make(map[string]bool, len(pk))
+	for _, k := range pk {
+		isPK[k] = true
+	}
+	for i, c := range cols {
+		names[i] = pgx.Identifier{c.Name}.Sanitize()
+		if !isPK[c.Name] {
+			updates = append(updates, names[i]+" = EXCLUDED."+names[i])
+		}
+	}
+	conflict := "DO NOTHING"
+	if len(updates) > 0 {
+		conflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	list := strings.Join(names, ", ")
+	tag, err := tx.Exec(ctx, "INSERT INTO "+target+" ("+list+") SELECT "+list+" FROM "+stage+
+		" ON CONFLICT ("+pgIdentList(pk)+") "+conflict)
+	if err != nil {
+		return 0, "", fmt.Errorf("upsert失败: %w", err)
+	}
+
+	// 回退窗口内重复读取的行不能让检查点倒退
+	watermark := watermarkString(last[0])
+	if compareWatermark(wm, watermark, from) < 0 {
+		watermark = from
+	}
+	return tag.RowsAffected(), watermark, nil
+}
+
+// lagWatermark 时间水位按SYNC.WATERMARK_LAG回退，覆盖检查点之前开始、之后才提交的事务写入的行
+func lagWatermark(wm mysqlColumn, v string) string {
+	lag := viper.GetDuration("SYNC.WATERMARK_LAG")
+	if lag <= 0 || !watermarkIsTime(wm) {
+		return v
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Add(-lag).Format("2006-01-02 15:04:05.999999")
+		}
+	}
+	return v
+}
+
+// compareWatermark 比较两个水位值，整数按数值比较，时间格式固定可按字符串比较
+func compareWatermark(wm mysqlColumn, a, b string) int {
+	if watermarkIsInt(wm) {
+		x, errA := strconv.ParseInt(a, 10, 64)
+		y, errB := strconv.ParseInt(b, 10, 64)
+		if errA == nil && errB == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// watermarkString 将驱动返回的水位值转为文本，作为下次查询的参数
+func watermarkString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,468 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// nativeSyncer 内置同步：按主键分块读取MySQL，以CSV格式通过COPY写入Postgres。
+// 全量模式每张表清空后重新写入；增量模式按水位列读取检查点之后的行，经临时表按主键upsert。
+// 每张表的写入与检查点在同一事务中，失败时目标表保持原数据；目标表需已由SyncSchema创建。
+// 过滤条件、排除列、目标表名、分块大小与并行读取数由sync_tables中的表规则决定
+type nativeSyncer struct {
+	srcDB    *sql.DB
+	src, tgt DBConfig
+	mode     string
+}
+
+func newNativeSyncer(srcDB *sql.DB, src, tgt DBConfig, mode string) *nativeSyncer {
+	return &nativeSyncer{srcDB: srcDB, src: src, tgt: tgt, mode: mode}
+}
+
+func (s *nativeSyncer) Name() string { return SyncBackendNative }
+
+// Prepare 创建增量检查点表，在并行同步各表之前执行一次
+func (s *nativeSyncer) Prepare(ctx context.Context, tgtDB *sql.DB) error {
+	if _, err := tgtDB.ExecContext(ctx, createSyncCheckpointSQL); err != nil {
+		return fmt.Errorf("创建同步检查点表失败: %w", err)
+	}
+	return nil
+}
+
+// syncFilter 源表的读取条件，cond为MySQL语法，为空表示不过滤
+type syncFilter struct {
+	cond string
+	args []any
+}
+
+// and 追加条件
+func (f syncFilter) and(cond string, args ...any) syncFilter {
+	if f.cond != "" {
+		cond = "(" + f.cond + ") and " + cond
+	}
+	return syncFilter{cond: cond, args: append(append([]any{}, f.args...), args...)}
+}
+
+// nativeTable 单表同步时的源表信息与规则
+type nativeTable struct {
+	name   string
+	rule   SyncTableRule
+	cols   []mysqlColumn
+	pk     []string
+	filter syncFilter
+}
+
+func (s *nativeSyncer) SyncTable(ctx context.Context, table string) (err error) {
+	ctx, span := tracer.Start(ctx, "sync table", trace.WithAttributes(
+		attribute.String("sync.table", table), attribute.String("sync.backend", SyncBackendNative)))
+	defer func() { endSpan(span, err) }()
+
+	t, err := s.loadTable(ctx, table)
+	if err != nil {
+		return err
+	}
+	wm, hasWM, err := syncWatermark(table, t.rule.Watermark, t.cols, t.pk)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := s.tgt.PgxConfig()
+	if err != nil {
+		return err
+	}
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("连接目标库失败: %w", err)
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	// 目标表由SyncSchema创建
+	target := t.rule.TargetTable()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	var cp syncCheckpoint
+	if hasWM {
+		if cp, err = loadSyncCheckpoint(ctx, tx, target); err != nil {
+			return err
+		}
+		// 源库或水位列变化后检查点失效，重新全量
+		if cp.SourceDatabase != s.src.Database || cp.Column != wm.Name {
+			cp = syncCheckpoint{}
+		}
+	}
+
+	var rows int64
+	var watermark string
+	mode := SyncModeFull
+	if s.mode == SyncModeIncremental && cp.Value != "" {
+		mode = SyncModeIncremental
+		rows, watermark, err = s.upsertSince(ctx, tx, t, target, wm, cp.Value)
+	} else {
+		if s.mode == SyncModeIncremental && !hasWM {
+			slog.WarnContext(ctx, "表没有可用的水位列或主键，全量同步", "table", table)
+		}
+		rows, watermark, err = s.replace(ctx, tx, t, target, wm, hasWM)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := resetIdentity(ctx, tx, target, t.cols); err != nil {
+		return err
+	}
+	if hasWM && watermark != "" {
+		if err := saveSyncCheckpoint(ctx, tx, target, syncCheckpoint{
+			SourceDatabase: s.src.Database, Column: wm.Name, Value: watermark, Rows: rows,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("提交失败: %w", err)
+	}
+
+	job := JobFromContext(ctx)
+	job.Add("tables_"+mode, 1)
+	span.SetAttributes(attribute.Int64("sync.rows", rows), attribute.String("sync.mode", mode))
+	countPipeline(job, JobSeatunnelMysqlPg, "rows_copied", rows)
+	slog.InfoContext(ctx, "表数据已复制", "table", table, "target", target, "mode", mode, "rows", rows, "watermark", watermark)
+	return nil
+}
+
+// loadTable 读取源表的列与主键，按同步规则去掉排除的列
+func (s *nativeSyncer) loadTable(ctx context.Context, table string) (nativeTable, error) {
+	t := nativeTable{name: table}
+	var err error
+	if t.rule, err = syncTableRule(table, s.tgt); err != nil {
+		return t, err
+	}
+	cols, err := mysqlColumns(ctx, s.srcDB, s.src.Database, table)
+	if err != nil {
+		return t, fmt.Errorf("读取表结构失败: %w", err)
+	}
+	if len(cols) == 0 {
+		return t, fmt.Errorf("表%s没有列", table)
+	}
+	if t.pk, err = mysqlPrimaryKey(ctx, s.srcDB, s.src.Database, table); err != nil {
+		return t, fmt.Errorf("读取主键失败: %w", err)
+	}
+	if t.cols, err = t.rule.Columns(table, cols, t.pk); err != nil {
+		return t, err
+	}
+	if t.rule.Where != "" {
+		t.filter = syncFilter{cond: t.rule.Where}
+	}
+	return t, nil
+}
+
+// resetIdentity 写入了源表的自增id，identity序列需要跟上，否则之后的插入会主键冲突
+func resetIdentity(ctx context.Context, tx pgx.Tx, target string, cols []mysqlColumn) error {
+	for _, c := range cols {
+		if !c.AutoIncrement() {
+			continue
+		}
+		col := pgx.Identifier{c.Name}.Sanitize()
+		if _, err := tx.Exec(ctx, "SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX("+col+"), 0) + 1, false) FROM "+target,
+			target, c.Name); err != nil {
+			return fmt.Errorf("更新自增序列失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// replace 全量刷新：清空目标表后按主键顺序写入。有水位列时在读取前记录其最大值作为检查点，
+// 读取期间更新的行在下次增量同步时会被再次读取
+func (s *nativeSyncer) replace(ctx context.Context, tx pgx.Tx, t nativeTable, target string,
+	wm mysqlColumn, hasWM bool) (int64, string, error) {
+	var watermark sql.NullString
+	if hasWM {
+		query := "select max(" + quoteMySQL(wm.Name) + ") from " + quoteMySQL(t.name)
+		if t.filter.cond != "" {
+			query += " where " + t.filter.cond
+		}
+		if err := s.srcDB.QueryRowContext(ctx, query, t.filter.args...).Scan(&watermark); err != nil {
+			return 0, "", fmt.Errorf("读取水位失败: %w", err)
+		}
+	}
+	parts, err := s.splitRanges(ctx, t)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := tx.Exec(ctx, "TRUNCATE "+target); err != nil {
+		return 0, "", fmt.Errorf("清空目标表失败: %w", err)
+	}
+	rows, _, err := s.copyRows(ctx, tx, t, target, t.pk, parts)
+	return rows, watermark.String, err
+}
+
+// splitRanges 按规则的parallelism将单列整数主键的取值范围均分，每段由一个读取线程处理；
+// 不满足条件时返回单段
+func (s *nativeSyncer) splitRanges(ctx context.Context, t nativeTable) ([]syncFilter, error) {
+	n := t.rule.Parallelism
+	if n <= 1 {
+		return []syncFilter{t.filter}, nil
+	}
+	var pkCol mysqlColumn
+	for _, c := range t.cols {
+		if len(t.pk) == 1 && c.Name == t.pk[0] {
+			pkCol = c
+		}
+	}
+	if !watermarkIsInt(pkCol) {
+		slog.WarnContext(ctx, "并行读取需要单列整数主键，按单线程读取", "table", t.name)
+		return []syncFilter{t.filter}, nil
+	}
+
+	col := quoteMySQL(pkCol.Name)
+	query := "select min(" + col + "), max(" + col + ") from " + quoteMySQL(t.name)
+	if t.filter.cond != "" {
+		query += " where " + t.filter.cond
+	}
+	var lo, hi sql.NullInt64
+	if err := s.srcDB.QueryRowContext(ctx, query, t.filter.args...).Scan(&lo, &hi); err != nil {
+		return nil, fmt.Errorf("读取主键范围失败: %w", err)
+	}
+	if !lo.Valid || hi.Int64-lo.Int64 < int64(n) {
+		return []syncFilter{t.filter}, nil
+	}
+	step := (hi.Int64-lo.Int64)/int64(n) + 1
+	parts := make([]syncFilter, 0, n)
+	for start := lo.Int64; start <= hi.Int64; start += step {
+		end := start + step - 1
+		if end > hi.Int64 {
+			end = hi.Int64
+		}
+		parts = append(parts, t.filter.and(col+" between ? and ?", start, end))
+	}
+	return parts, nil
+}
+
+// copyRows 并行读取源表的各段(keys为keyset分页的列)，通过一个COPY写入target，
+// 返回写入行数；只有一段时同时返回最后一行的keys值
+func (s *nativeSyncer) copyRows(ctx context.Context, tx pgx.Tx, t nativeTable, target string,
+	keys []string, parts []syncFilter) (int64, []any, error) {
+	// 读取与写入通过管道并行，每个读取线程在内存中最多保留一个分块。
+	// io.Pipe的并发Write按顺序整体写入，每次Write都是完整的若干行
+	pr, pw := io.Pipe()
+	var last []any
+	readErr := make(chan error, 1)
+	go func() {
+		errs := make(chan error, len(parts))
+		var wg sync.WaitGroup
+		for i, part := range parts {
+			wg.Add(1)
+			go func(i int, part syncFilter) {
+				defer wg.Done()
+				l, err := s.writeCSV(ctx, pw, t, keys, part)
+				if i == 0 && len(parts) == 1 {
+					last = l
+				}
+				errs <- err
+			}(i, part)
+		}
+		wg.Wait()
+		close(errs)
+		var err error
+		for e := range errs {
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+		pw.CloseWithError(err)
+		readErr <- err
+	}()
+
+	names := make([]string, len(t.cols))
+	for i, c := range t.cols {
+		names[i] = pgx.Identifier{c.Name}.Sanitize()
+	}
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, pr,
+		"COPY "+target+" ("+strings.Join(names, ", ")+") FROM STDIN WITH (FORMAT csv)")
+	// 写入失败时关闭读端，让读取goroutine退出
+	pr.CloseWithError(io.ErrClosedPipe)
+	if rerr := <-readErr; rerr != nil && rerr != io.ErrClosedPipe {
+		return 0, nil, fmt.Errorf("读取源表失败: %w", rerr)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("COPY失败: %w", err)
+	}
+	return tag.RowsAffected(), last, nil
+}
+
+// writeCSV 按keys做keyset分页读取源表满足filter的行，每块rule.ChunkSize行，以CSV写入w，
+// 返回最后一行的keys值；没有keys时一次流式读取
+func (s *nativeSyncer) writeCSV(ctx context.Context, w io.Writer, t nativeTable, keys []string, filter syncFilter) ([]any, error) {
+	pgTypes := make([]string, len(t.cols))
+	selectCols := make([]string, len(t.cols))
+	colIndex := make(map[string]int, len(t.cols))
+	for i, c := range t.cols {
+		pgTypes[i] = pgColumnType(c)
+		selectCols[i] = quoteMySQL(c.Name)
+		colIndex[c.Name] = i
+	}
+	keyCols := make([]string, len(keys))
+	for i, name := range keys {
+		keyCols[i] = quoteMySQL(name)
+	}
+	base := "select " + strings.Join(selectCols, ", ") + " from " + quoteMySQL(t.name)
+	chunkSize := t.rule.ChunkSize
+
+	var buf bytes.Buffer
+	var last []any
+	for {
+		f := filter
+		if last != nil {
+			f = f.and("("+strings.Join(keyCols, ", ")+") > ("+
+				strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")+")", last...)
+		}
+		query, args := base, f.args
+		if f.cond != "" {
+			query += " where " + f.cond
+		}
+		if len(keys) > 0 {
+			query += " order by " + strings.Join(keyCols, ", ") + " limit ?"
+			args = append(append([]any{}, args...), chunkSize)
+		}
+
+		n, lastRow, err := s.copyChunk(ctx, w, &buf, query, args, t.cols, pgTypes)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 && len(keys) > 0 {
+			last = make([]any, len(keys))
+			for i, name := range keys {
+				last[i] = lastRow[colIndex[name]]
+			}
+		}
+		if len(keys) == 0 || n < chunkSize {
+			break
+		}
+	}
+	return last, nil
+}
+
+// csvFlushSize 读取线程缓冲的CSV超过该大小时写出，每次写出的都是完整的行
+const csvFlushSize = 64 * 1024
+
+// copyChunk 执行一次分块查询并以CSV写出，返回行数与最后一行的值
+func (s *nativeSyncer) copyChunk(ctx context.Context, w io.Writer, buf *bytes.Buffer, query string, args []any,
+	cols []mysqlColumn, pgTypes []string) (int, []any, error) {
+	rows, err := s.srcDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	n := 0
+	var values []any
+	for rows.Next() {
+		values = make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, nil, err
+		}
+		for i, v := range values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			// CSV中未加引号的空字段为NULL，加引号的空字段为空字符串
+			if text, ok := csvValue(cols[i], pgTypes[i], v); ok {
+				buf.WriteByte('"')
+				buf.WriteString(strings.ReplaceAll(text, `"`, `""`))
+				buf.WriteByte('"')
+			}
+		}
+		buf.WriteByte('\n')
+		n++
+		if buf.Len() >= csvFlushSize {
+			if err := flushCSV(w, buf); err != nil {
+				return n, nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, nil, err
+	}
+	return n, values, flushCSV(w, buf)
+}
+
+// flushCSV 写出缓冲的完整行
+func flushCSV(w io.Writer, buf *bytes.Buffer) error {
+	if buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(buf.Bytes())
+	buf.Reset()
+	return err
+}
+
+// csvValue 将MySQL驱动返回的值转换为Postgres可解析的文本，返回false表示NULL。
+// MySQL的零日期在Postgres中无法表示，写为NULL
+func csvValue(c mysqlColumn, pgType string, v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case []byte:
+		switch {
+		case pgType == "bytea":
+			return `\x` + hex.EncodeToString(v), true
+		case c.DataType == "bit":
+			var n uint64
+			for _, b := range v {
+				n = n<<8 | uint64(b)
+			}
+			if pgType == "boolean" {
+				return strconv.FormatBool(n != 0), true
+			}
+			return strconv.FormatUint(n, 10), true
+		}
+		text := string(v)
+		if (pgType == "date" || pgType == "timestamp") && strings.HasPrefix(text, "0000-00-00") {
+			return "", false
+		}
+		// Postgres文本类型不允许\x00
+		return strings.ReplaceAll(text, "\x00", ""), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case time.Time:
+		if v.IsZero() {
+			return "", false
+		}
+		return v.Format("2006-01-02 15:04:05.999999"), true
+	case string:
+		return strings.ReplaceAll(v, "\x00", ""), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// quoteMySQL 引用MySQL标识符
+func quoteMySQL(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
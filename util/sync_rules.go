@@ -0,0 +1,156 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
+)
+
+// SyncTableRule 单表同步规则，对应配置文件sync_tables.<源表名>，native与seatunnel后端及表结构同步共用
+type SyncTableRule struct {
+	Target         string   `mapstructure:"target"`          // 目标表名，默认与源表相同
+	Schema         string   `mapstructure:"schema"`          // 目标schema，默认postgres_tgt.SCHEMA
+	Where          string   `mapstructure:"where"`           // 源表过滤条件，MySQL语法
+	ExcludeColumns []string `mapstructure:"exclude_columns"` // 不同步的列，不能包含主键
+	Watermark      string   `mapstructure:"watermark"`       // 增量同步的水位列，默认按SYNC.WATERMARK_COLUMNS检测
+	ChunkSize      int      `mapstructure:"chunk_size"`      // native分块行数 / seatunnel batch_size，默认SYNC.CHUNK_SIZE
+	Parallelism    int      `mapstructure:"parallelism"`     // 单表并行读取数，需单列整数主键，默认1
+}
+
+// syncTableRule 读取源表的同步规则并填充默认值。viper的键为小写，按小写表名查找
+func syncTableRule(table string, tgt DBConfig) (SyncTableRule, error) {
+	var rule SyncTableRule
+	if err := viper.UnmarshalKey("sync_tables."+strings.ToLower(table), &rule); err != nil {
+		return rule, fmt.Errorf("解析sync_tables.%s配置失败: %w", table, err)
+	}
+	if rule.Target == "" {
+		rule.Target = table
+	}
+	if rule.Schema == "" {
+		rule.Schema = tgt.Schema
+	}
+	if rule.ChunkSize <= 0 {
+		rule.ChunkSize = viper.GetInt("SYNC.CHUNK_SIZE")
+	}
+	if rule.ChunkSize <= 0 {
+		rule.ChunkSize = 5000
+	}
+	if rule.Parallelism <= 0 {
+		rule.Parallelism = 1
+	}
+	return rule, nil
+}
+
+// TargetTable 带schema的目标表名，已转义
+func (r SyncTableRule) TargetTable() string {
+	return pgx.Identifier{r.Schema, r.Target}.Sanitize()
+}
+
+// Columns 去掉排除的列，排除主键时返回错误
+func (r SyncTableRule) Columns(table string, cols []mysqlColumn, pk []string) ([]mysqlColumn, error) {
+	if len(r.ExcludeColumns) == 0 {
+		return cols, nil
+	}
+	excluded := make(map[string]bool, len(r.ExcludeColumns))
+	for _, name := range r.ExcludeColumns {
+		excluded[strings.ToLower(name)] = true
+	}
+	for _, k := range pk {
+		if excluded[strings.ToLower(k)] {
+			return nil, fmt.Errorf("表%s的主键列%s不能排除", table, k)
+		}
+	}
+	kept := make([]mysqlColumn, 0, len(cols))
+	for _, c := range cols {
+		if !excluded[strings.ToLower(c.Name)] {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("表%s的所有列都被排除", table)
+	}
+	return kept, nil
+}
+
+// selectSyncTables 返回需要同步的源表：requested非空时只同步指定的表，否则为源库所有表；
+// 两种情况都按SYNC.INCLUDE、SYNC.EXCLUDE过滤，指定的表不存在或被排除时返回错误
+func selectSyncTables(ctx context.Context, srcDB *sql.DB, requested []string) ([]string, error) {
+	include := viper.GetStringSlice("SYNC.INCLUDE")
+	exclude := viper.GetStringSlice("SYNC.EXCLUDE")
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("表名匹配模式%q无效: %w", p, err)
+		}
+	}
+
+	all, err := getMySQLTables(ctx, srcDB)
+	if err != nil {
+		return nil, fmt.Errorf("获取表失败: %w", err)
+	}
+	lower := make(map[string]bool, len(all))
+	for _, table := range all {
+		lower[strings.ToLower(table)] = true
+	}
+	for name := range viper.GetStringMap("sync_tables") {
+		if !lower[name] {
+			slog.WarnContext(ctx, "sync_tables配置的表在源库中不存在", "table", name)
+		}
+	}
+	if len(requested) > 0 {
+		for _, table := range requested {
+			if !contains(all, table) {
+				return nil, fmt.Errorf("源库中不存在表%s", table)
+			}
+			if !syncTableSelected(table, include, exclude) {
+				return nil, fmt.Errorf("表%s被SYNC.INCLUDE/SYNC.EXCLUDE排除", table)
+			}
+		}
+		return requested, nil
+	}
+
+	var tables []string
+	for _, table := range all {
+		if syncTableSelected(table, include, exclude) {
+			tables = append(tables, table)
+		}
+	}
+	if skipped := len(all) - len(tables); skipped > 0 {
+		slog.InfoContext(ctx, "按表名规则跳过的表", "count", skipped)
+	}
+	return tables, nil
+}
+
+// syncTableSelected include为空时包含所有表，exclude优先
+func syncTableSelected(table string, include, exclude []string) bool {
+	for _, p := range exclude {
+		if ok, _ := path.Match(p, table); ok {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, p := range include {
+		if ok, _ := path.Match(p, table); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTables 解析逗号分隔的表名参数
+func splitTables(s string) []string {
+	var tables []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
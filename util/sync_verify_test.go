@@ -0,0 +1,66 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// TestVerifyValue MySQL驱动返回的值经csvValue与verifyValue后，应与Postgres ::text输出规范化后的文本一致
+func TestVerifyValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType string // MySQL列类型
+		pgType   string
+		src      any // MySQL驱动返回的值
+		pg       string
+		equal    bool
+	}{
+		{"decimal末尾的0", "decimal", "numeric(10,2)", []byte("12.50"), "12.5", true},
+		{"decimal整数", "decimal", "numeric(10,2)", []byte("12.00"), "12", true},
+		{"decimal负零", "decimal", "numeric(10,2)", []byte("-0.00"), "0.00", true},
+		{"decimal整数部分的0不去掉", "decimal", "numeric", []byte("100"), "1", false},
+		{"decimal值不同", "decimal", "numeric(10,2)", []byte("1.50"), "1.05", false},
+		{"datetime无小数秒", "datetime", "timestamp", time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC), "2024-03-01 08:30:00", true},
+		{"datetime小数秒", "datetime", "timestamp", time.Date(2024, 3, 1, 8, 30, 0, 500000000, time.UTC), "2024-03-01 08:30:00.5", true},
+		{"datetime(6)末尾的0", "datetime", "timestamp", []byte("2024-03-01 08:30:00.120000"), "2024-03-01 08:30:00.12", true},
+		{"datetime小数秒不同", "datetime", "timestamp", time.Date(2024, 3, 1, 8, 30, 0, 1000, time.UTC), "2024-03-01 08:30:00", false},
+		{"float32", "float", "real", float32(3.14), "3.14", true},
+		{"float文本多余的位数", "float", "real", []byte("3.1400001"), "3.14", true},
+		{"double", "double", "double precision", float64(0.1) + float64(0.2), "0.30000000000000004", true},
+		{"double科学计数法", "double", "double precision", []byte("1e21"), "1e+21", true},
+		{"blob转bytea的hex", "blob", "bytea", []byte{0xde, 0xad, 0x00, 0x01}, `\xdead0001`, true},
+		{"bytea内容不同", "varbinary", "bytea", []byte{0xde, 0xad}, `\xdeae`, false},
+		{"jsonb键顺序与空白", "json", "jsonb", []byte(`{"b":1,"a":[1,"<x>"]}`), `{"a": [1, "<x>"], "b": 1}`, true},
+		{"jsonb嵌套对象", "json", "jsonb", []byte(`{"z":{"y":null,"x":true}}`), `{"z": {"x": true, "y": null}}`, true},
+		{"jsonb值不同", "json", "jsonb", []byte(`{"a":1}`), `{"a": 2}`, false},
+		{"time转interval", "time", "interval", []byte("08:30:00"), "08:30:00", true},
+		{"time超过24小时", "time", "interval", []byte("838:59:59"), "838:59:59", true},
+		{"time负值", "time", "interval", []byte("-01:30:00"), "-01:30:00", true},
+		{"time(6)末尾的0", "time", "interval", []byte("08:30:00.500000"), "08:30:00.5", true},
+		{"time(3)全为0的小数秒", "time", "interval", []byte("08:30:00.000"), "08:30:00", true},
+		{"interval按天输出的部分不变", "time", "interval", []byte("1 day 02:00:00"), "1 day 02:00:00", true},
+		{"Postgres time类型不规范化", "time", "time", []byte("08:30:00.500"), "08:30:00.5", false},
+		{"文本原样比较", "varchar", "varchar(32)", []byte("1.50"), "1.5", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, ok := csvValue(mysqlColumn{DataType: tt.dataType}, tt.pgType, tt.src)
+			if !ok {
+				t.Fatalf("csvValue(%v) 返回NULL", tt.src)
+			}
+			src, pg := verifyValue(tt.pgType, text), verifyValue(tt.pgType, tt.pg)
+			if (src == pg) != tt.equal {
+				t.Errorf("verifyValue(%q, %q) = %q, verifyValue(%q) = %q, equal应为%v", tt.pgType, text, src, tt.pg, pg, tt.equal)
+			}
+		})
+	}
+}
+
+func TestVerifyValueNull(t *testing.T) {
+	// 零日期写为NULL，与目标端的NULL一致
+	for _, v := range []any{nil, []byte("0000-00-00 00:00:00"), time.Time{}} {
+		if text, ok := csvValue(mysqlColumn{DataType: "datetime"}, "timestamp", v); ok {
+			t.Errorf("csvValue(%v) = %q, 应为NULL", v, text)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// MySQL到Postgres的同步后端，由SYNC.BACKEND选择
+const (
+	SyncBackendNative    = "native"    // 内置COPY同步
+	SyncBackendSeatunnel = "seatunnel" // 调用Seatunnel
+)
+
+// 同步模式，由SYNC.MODE或任务参数mode选择
+const (
+	SyncModeFull        = "full"        // 清空目标表后全量写入
+	SyncModeIncremental = "incremental" // 按水位列读取检查点之后的行，按主键upsert
+)
+
+// Syncer 单表同步后端
+type Syncer interface {
+	Name() string
+	SyncTable(ctx context.Context, table string) error
+}
+
+// seatunnelTableSyncer 能返回Seatunnel作业结果的后端
+type seatunnelTableSyncer interface {
+	SeatunnelSyncTable(ctx context.Context, table string) (*SeatunnelResult, error)
+}
+
+// SyncTableResult 单表同步结果
+type SyncTableResult struct {
+	Table           string           `json:"table"`
+	Succeeded       bool             `json:"succeeded"`
+	Error           string           `json:"error,omitempty"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Seatunnel       *SeatunnelResult `json:"seatunnel,omitempty"`
+}
+
+// SyncReport 同步任务结果，同步过程中随任务状态返回已完成的表
+type SyncReport struct {
+	Backend string            `json:"backend"`
+	Mode    string            `json:"mode"`
+	Tables  []SyncTableResult `json:"tables"`
+	Verify  *SyncVerifyReport `json:"verify,omitempty"`
+
+	mu sync.Mutex
+}
+
+// add 并发追加单表结果
+func (r *SyncReport) add(t SyncTableResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Tables = append(r.Tables, t)
+}
+
+// MarshalJSON 任务运行中查询状态时与add并发
+func (r *SyncReport) MarshalJSON() ([]byte, error) {
+	type report SyncReport
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Marshal((*report)(r))
+}
+
+// syncPreparer 需要在并行同步前初始化目标库的后端
+type syncPreparer interface {
+	Prepare(ctx context.Context, tgtDB *sql.DB) error
+}
+
+// NewSyncer 按SYNC.BACKEND创建同步后端，srcDB为源库连接池，mode为同步模式
+func NewSyncer(srcDB *sql.DB, src, tgt DBConfig, mode string) (Syncer, error) {
+	if mode != SyncModeFull && mode != SyncModeIncremental {
+		return nil, fmt.Errorf("不支持的同步模式: %s", mode)
+	}
+	switch backend := viper.GetString("SYNC.BACKEND"); backend {
+	case SyncBackendNative:
+		return newNativeSyncer(srcDB, src, tgt, mode), nil
+	case SyncBackendSeatunnel:
+		if mode != SyncModeFull {
+			return nil, fmt.Errorf("seatunnel后端只支持全量同步")
+		}
+		return seatunnelSyncer{srcDB: srcDB, src: src, tgt: tgt}, nil
+	default:
+		return nil, fmt.Errorf("不支持的SYNC.BACKEND: %s", backend)
+	}
+}
+
+// 获取MySQL数据库中的所有表名
+func getMySQLTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SHOW TABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// SyncMySQLToPG 主同步流程，按SYNC.INCLUDE、SYNC.EXCLUDE选择源库的表，SYNC.SCHEMA_SYNC启用时先同步表结构，
+// 再由SYNC.WORKERS个工作线程并行同步数据；srcDB、tgtDB为源库、目标库连接池。
+// 任务参数mode覆盖SYNC.MODE，tables(逗号分隔)只同步指定的表；每张表同步成功后按SYNC.VERIFY校验，
+// 任务参数verify、repair覆盖SYNC.VERIFY、SYNC.VERIFY_REPAIR；各表同步与校验结果以SyncReport通过任务result返回
+func SyncMySQLToPG(ctx context.Context, srcDB, tgtDB *sql.DB, src, tgt DBConfig) error {
+	job := JobFromContext(ctx)
+
+	mode := job.Param("mode")
+	if mode == "" {
+		mode = viper.GetString("SYNC.MODE")
+	}
+	syncer, err := NewSyncer(srcDB, src, tgt, mode)
+	if err != nil {
+		return err
+	}
+	if p, ok := syncer.(syncPreparer); ok {
+		if err := p.Prepare(ctx, tgtDB); err != nil {
+			return err
+		}
+	}
+
+	tables, err := selectSyncTables(ctx, srcDB, splitTables(job.Param("tables")))
+	if err != nil {
+		slog.ErrorContext(ctx, "获取表失败", "error", err)
+		return err
+	}
+
+	// 未启用表结构同步时，原生同步仍创建缺少的目标表，已存在的表不修改
+	schemaSync := viper.GetBool("SYNC.SCHEMA_SYNC")
+	if schemaSync || syncer.Name() == SyncBackendNative {
+		var report *SchemaSyncReport
+		if schemaSync {
+			report, err = SyncSchema(ctx, srcDB, tgtDB, src, tgt, tables, false)
+		} else {
+			report, err = CreateMissingTables(ctx, srcDB, tgtDB, src, tgt, tables)
+		}
+		if err != nil {
+			return err
+		}
+		// 表结构同步失败的表不再同步数据
+		failed := report.Failed()
+		synced := tables[:0:0]
+		for _, table := range tables {
+			if !failed[table] {
+				synced = append(synced, table)
+			}
+		}
+		tables = synced
+	}
+
+	verifyMode, repair := syncVerifyMode(job, viper.GetString("SYNC.VERIFY"))
+	verifier, err := newSyncVerifier(srcDB, tgtDB, src, tgt, verifyMode, repair)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "发现待同步的表", "count", len(tables), "backend", syncer.Name(), "mode", mode,
+		"verify", verifyMode, "workers", viper.GetInt("SYNC.WORKERS"))
+	report := &SyncReport{Backend: syncer.Name(), Mode: mode}
+	if verifier != nil {
+		report.Verify = verifier.report
+	}
+	job.SetResult(report)
+	job.SetTotal(int64(len(tables)))
+	forEachTable(ctx, tables, func(ctx context.Context, table string) {
+		result := syncOneTable(ctx, syncer, table)
+		report.add(result)
+		// 同步失败的表不校验
+		if result.Succeeded && verifier != nil {
+			_ = verifier.VerifyTable(ctx, table)
+		}
+		job.Step(1)
+	})
+
+	slog.InfoContext(ctx, "同步任务完成")
+	return ctx.Err()
+}
+
+// forEachTable 由SYNC.WORKERS个工作线程并行处理各表，ctx取消后不再分发新的表
+func forEachTable(ctx context.Context, tables []string, fn func(ctx context.Context, table string)) {
+	workers := viper.GetInt("SYNC.WORKERS")
+	if workers < 1 {
+		workers = 1
+	}
+	tableCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for table := range tableCh {
+				fn(ctx, table)
+			}
+		}()
+	}
+	for _, table := range tables {
+		if ctx.Err() != nil {
+			break
+		}
+		tableCh <- table
+	}
+	close(tableCh)
+	wg.Wait()
+}
+
+// syncOneTable 同步单表并记录结果，失败不影响其他表
+func syncOneTable(ctx context.Context, syncer Syncer, table string) SyncTableResult {
+	job := JobFromContext(ctx)
+	slog.InfoContext(ctx, "同步表", "table", table)
+	start := time.Now()
+	result := SyncTableResult{Table: table}
+	var err error
+	if st, ok := syncer.(seatunnelTableSyncer); ok {
+		result.Seatunnel, err = st.SeatunnelSyncTable(ctx, table)
+	} else {
+		err = syncer.SyncTable(ctx, table)
+	}
+	result.DurationSeconds = time.Since(start).Seconds()
+	syncTableDuration.WithLabelValues(table).Set(result.DurationSeconds)
+	if err != nil {
+		result.Error = err.Error()
+		slog.ErrorContext(ctx, "同步失败", "table", table, "error", err)
+		job.Add("tables_failed", 1)
+		job.RecordError(fmt.Errorf("%s: %w", table, err))
+		syncTableSuccess.WithLabelValues(table).Set(0)
+		syncTablesTotal.WithLabelValues("failed").Inc()
+	} else {
+		slog.InfoContext(ctx, "同步成功", "table", table, "duration", time.Since(start))
+		job.Add("tables_synced", 1)
+		syncTableSuccess.WithLabelValues(table).Set(1)
+		syncTablesTotal.WithLabelValues("succeeded").Inc()
+	}
+	result.Succeeded = err == nil
+	return result
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer 全局tracer，InitTracing前及未启用时为no-op
+var tracer = otel.Tracer("go-sms")
+
+// InitTracing 按TRACING.EXPORTER初始化OpenTelemetry: none(默认，不导出) / stdout / otlp。
+// stdout导出器写到w，命令行模式传入标准错误以免混入命令输出；
+// 返回的shutdown在退出前调用，导出缓冲中尚未发送的span
+func InitTracing(w io.Writer) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+	var exporter sdktrace.SpanExporter
+	switch name := strings.ToLower(viper.GetString("TRACING.EXPORTER")); name {
+	case "", "none":
+		slog.Info("链路追踪未启用")
+		return shutdown, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case "otlp":
+		// 未配置ENDPOINT时使用OTEL_EXPORTER_OTLP_ENDPOINT等标准环境变量
+		var opts []otlptracehttp.Option
+		if endpoint := viper.GetString("TRACING.OTLP_ENDPOINT"); endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return shutdown, fmt.Errorf("不支持的TRACING.EXPORTER: %s", name)
+	}
+	if err != nil {
+		return shutdown, fmt.Errorf("创建链路追踪导出器失败: %w", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName(viper.GetString("TRACING.SERVICE_NAME"))),
+	)
+	if err != nil {
+		return shutdown, fmt.Errorf("创建链路追踪资源失败: %w", err)
+	}
+
+	ratio := viper.GetFloat64("TRACING.SAMPLE_RATIO")
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	slog.Info("链路追踪已启用", "exporter", viper.GetString("TRACING.EXPORTER"), "sample_ratio", ratio)
+	return provider.Shutdown, nil
+}
+
+// endSpan 结束span，err非空时记录错误并标记为失败
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startDifySpan 每次调用Dify接口一个span，重试时attempt递增
+func startDifySpan(ctx context.Context, app string, attempt int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "dify "+app,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("dify.app", app), attribute.Int("dify.attempt", attempt+1)),
+	)
+}
+
+// openDB 按连接配置打开数据库，每条SQL语句自动生成span
+func openDB(cfg DBConfig) (*sql.DB, error) {
+	connector, err := cfg.connector()
+	if err != nil {
+		return nil, err
+	}
+	system := cfg.Driver
+	if system == "postgres" {
+		system = "postgresql"
+	}
+	return otelsql.OpenDB(connector,
+		otelsql.WithAttributes(attribute.String("db.system", system)),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	), nil
+}